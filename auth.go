@@ -0,0 +1,197 @@
+package httpc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/Azure/go-ntlmssp"
+)
+
+// token按返回401的host保存,只发送给该host,避免把凭据泄露给其他域名
+type reauthState struct {
+	refresh func() (string, error)
+	mu      sync.Mutex
+	tokens  map[string]string
+}
+
+// 返回host最近一次刷新得到的token,还没有刷新过时返回空字符串
+func (r *reauthState) bearer(host string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tokens[host]
+}
+
+// noReauthKey标记使用Digest或NTLM认证的请求,这类请求的401由握手处理,不刷新token
+type noReauthKey struct{}
+
+// 只有请求本身带了Bearer token,或者401的WWW-Authenticate要求Bearer时才刷新,
+// 避免把token发给不使用Bearer认证的host
+func (r *reauthState) wantsBearer(req *http.Request, rsp *http.Response) bool {
+	if req.Context().Value(noReauthKey{}) != nil {
+		return false
+	}
+	if v := req.Header.Get("Authorization"); v != "" {
+		scheme, _, _ := strings.Cut(v, " ")
+		return strings.EqualFold(scheme, "Bearer")
+	}
+	_, ok := authChallenge(rsp.Header, "Bearer")
+	return ok
+}
+
+// 收到401时刷新token并重试一次,重试仍然返回401时直接返回,不会再次刷新。
+// 请求体无法重新读取时返回原来的401响应,刷新失败时返回刷新函数的错误
+func (r *reauthState) do(req *http.Request, next RoundTripFunc) (*http.Response, error) {
+	rsp, err := next(req)
+	if err != nil || rsp.StatusCode != http.StatusUnauthorized || !r.wantsBearer(req, rsp) {
+		return rsp, err
+	}
+	retry := replayRequest(req)
+	if retry == nil {
+		return rsp, nil
+	}
+	// 刷新前先读完并关闭401响应,释放连接和SetMaxConcurrentPerHost的名额,
+	// 刷新函数通过同一个客户端请求同一个host时不会死锁
+	body, err := ioutil.ReadAll(rsp.Body)
+	_ = rsp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	rsp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	token, err := r.refresh()
+	if err != nil {
+		return nil, fmt.Errorf("reauth: refresh token: %w", err)
+	}
+	r.mu.Lock()
+	r.tokens[req.URL.Host] = token
+	r.mu.Unlock()
+
+	retry.Header.Set("Authorization", "Bearer "+token)
+	return next(retry)
+}
+
+// SetReauth设置token刷新函数,请求返回401时调用f获取新的token,更新Authorization后重试一次。
+// 只在请求带有Bearer token或服务端的WWW-Authenticate要求Bearer时刷新,使用Basic、Digest、NTLM认证的请求不受影响,
+// f返回的错误会作为请求的错误返回。
+// 新的token只用于返回401的host的后续请求,Request.SetBearerToken等设置的请求头仍然优先。
+// 开启后multipart请求体会先读入内存以便重新发送
+func (this *HttpClient) SetReauth(f func() (newBearer string, err error)) *HttpClient {
+	if f == nil {
+		this.reauth = nil
+		return this
+	}
+	this.reauth = &reauthState{refresh: f, tokens: map[string]string{}}
+	return this
+}
+
+type ntlmAuth struct {
+	user     string
+	password string
+	domain   string
+}
+
+// SetNTLMAuth使用NTLM(Windows集成认证)认证,服务端返回401且WWW-Authenticate为NTLM或Negotiate时完成握手并重新发送。
+// user为UPN格式(user@example.com)时不使用domain。握手依赖连接复用,只支持HTTP/1.1,
+// multipart请求体会先读入内存以便重新发送,SetBodyReader的流式请求体无法重新发送,此时返回原来的401响应
+func (this *Request) SetNTLMAuth(user, password, domain string) *Request {
+	this.ntlm = &ntlmAuth{user: user, password: password, domain: domain}
+	return this
+}
+
+// 发送请求,设置了NTLM或Digest认证时在收到401后完成握手
+func (this *Request) do(req *http.Request) (*http.Response, error) {
+	rsp, err := this.httpc.do(req)
+	if err != nil || rsp.StatusCode != http.StatusUnauthorized {
+		return rsp, err
+	}
+	if this.ntlm != nil {
+		return this.ntlm.handshake(req, rsp, this.httpc.do)
+	}
+	if this.digest != nil {
+		return this.digest.handshake(req, rsp, this.httpc.do)
+	}
+	return rsp, nil
+}
+
+// 返回WWW-Authenticate中scheme对应的参数,如"NTLM TlRMTVNTUAACAAAA..."返回"TlRMTVNTUAACAAAA..."
+func authChallenge(h http.Header, scheme string) (string, bool) {
+	for _, v := range h.Values("WWW-Authenticate") {
+		name, param, _ := strings.Cut(strings.TrimSpace(v), " ")
+		if strings.EqualFold(name, scheme) {
+			return strings.TrimSpace(param), true
+		}
+	}
+	return "", false
+}
+
+// 请求体无法重新读取时返回nil
+func replayRequest(req *http.Request) *http.Request {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return nil
+	}
+	r := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil
+		}
+		r.Body = body
+	}
+	return r
+}
+
+func discardBody(rsp *http.Response) {
+	_, _ = io.Copy(ioutil.Discard, rsp.Body)
+	_ = rsp.Body.Close()
+}
+
+func (a *ntlmAuth) handshake(req *http.Request, rsp *http.Response, next RoundTripFunc) (*http.Response, error) {
+	scheme := "NTLM"
+	if _, ok := authChallenge(rsp.Header, scheme); !ok {
+		if _, ok = authChallenge(rsp.Header, "Negotiate"); !ok {
+			return rsp, nil
+		}
+		scheme = "Negotiate"
+	}
+	final := replayRequest(req)
+	if final == nil {
+		return rsp, nil
+	}
+	discardBody(rsp)
+
+	negotiate, err := ntlmssp.NewNegotiateMessage(a.domain, "")
+	if err != nil {
+		return nil, err
+	}
+	// 协商阶段不需要请求体
+	leg := req.Clone(req.Context())
+	leg.Body, leg.GetBody, leg.ContentLength = http.NoBody, nil, 0
+	leg.Header.Set("Authorization", scheme+" "+base64.StdEncoding.EncodeToString(negotiate))
+	rsp, err = next(leg)
+	if err != nil {
+		return nil, err
+	}
+	param, _ := authChallenge(rsp.Header, scheme)
+	if rsp.StatusCode != http.StatusUnauthorized || param == "" {
+		// 服务端没有返回challenge,由调用方处理该响应
+		return rsp, nil
+	}
+	challenge, err := base64.StdEncoding.DecodeString(param)
+	if err != nil {
+		discardBody(rsp)
+		return nil, fmt.Errorf("ntlm: decode challenge: %w", err)
+	}
+	discardBody(rsp)
+
+	authenticate, err := ntlmssp.ProcessChallenge(challenge, a.user, a.password, !strings.Contains(a.user, "@"))
+	if err != nil {
+		return nil, fmt.Errorf("ntlm: %w", err)
+	}
+	final.Header.Set("Authorization", scheme+" "+base64.StdEncoding.EncodeToString(authenticate))
+	return next(final)
+}
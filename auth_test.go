@@ -0,0 +1,156 @@
+package httpc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 构造最小的NTLM CHALLENGE消息,TargetName为DOM,TargetInfo只有MsvAvEOL
+func ntlmChallenge() string {
+	name := []byte{'D', 0, 'O', 0, 'M', 0}
+	info := []byte{0, 0, 0, 0}
+	var b bytes.Buffer
+	b.WriteString("NTLMSSP\x00")
+	binary.Write(&b, binary.LittleEndian, uint32(2))
+	binary.Write(&b, binary.LittleEndian, [2]uint16{uint16(len(name)), uint16(len(name))})
+	binary.Write(&b, binary.LittleEndian, uint32(48))
+	binary.Write(&b, binary.LittleEndian, uint32(0x00800201))
+	b.WriteString("12345678")
+	b.Write(make([]byte, 8))
+	binary.Write(&b, binary.LittleEndian, [2]uint16{uint16(len(info)), uint16(len(info))})
+	binary.Write(&b, binary.LittleEndian, uint32(48+len(name)))
+	b.Write(name)
+	b.Write(info)
+	return base64.StdEncoding.EncodeToString(b.Bytes())
+}
+
+func TestNTLMHandshake(t *testing.T) {
+	var legs []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "NTLM ") {
+			legs = append(legs, "anonymous:"+string(body))
+			w.Header().Add("WWW-Authenticate", "NTLM")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		msg, _ := base64.StdEncoding.DecodeString(auth[5:])
+		switch msg[8] {
+		case 1:
+			legs = append(legs, "negotiate:"+string(body))
+			w.Header().Set("WWW-Authenticate", "NTLM "+ntlmChallenge())
+			w.WriteHeader(http.StatusUnauthorized)
+		case 3:
+			legs = append(legs, "authenticate:"+string(body))
+			w.Write([]byte("welcome"))
+		}
+	}))
+	defer s.Close()
+
+	_, body, err := NewRequest(NewHttpClient()).SetMethod("POST").SetUrl(s.URL).
+		SetBody([]byte("payload"), "text/plain").SetNTLMAuth("bob", "pw", "DOM").Send().End()
+	if err != nil || body != "welcome" {
+		t.Fatalf("body %q, err %v", body, err)
+	}
+	want := "anonymous:payload negotiate: authenticate:payload"
+	if got := strings.Join(legs, " "); got != want {
+		t.Fatalf("legs %q, want %q", got, want)
+	}
+}
+
+func TestReauthScopedToHost(t *testing.T) {
+	var other []string
+	// 其他host总是返回401,也不能收到token
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		other = append(other, r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer b.Close()
+	a := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			w.Write([]byte("secret"))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer a.Close()
+
+	var c *HttpClient
+	// 刷新函数通过同一个客户端请求同一个host,SetMaxConcurrentPerHost(1)时也不能死锁
+	c = NewHttpClient().SetMaxConcurrentPerHost(1).SetReauth(func() (string, error) {
+		_, token, err := NewRequest(c).SetUrl(a.URL + "/token").Send().End()
+		return token, err
+	})
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := NewRequest(c).SetUrl(a.URL).Send().End()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("reauth deadlocked")
+	}
+
+	rsp, _, _ := NewRequest(c).SetUrl(b.URL).Send().End()
+	if rsp == nil || rsp.StatusCode != http.StatusUnauthorized || len(other) != 1 || other[0] != "" {
+		t.Fatalf("token sent to another host: %q", other)
+	}
+	if _, body, err := NewRequest(c).SetUrl(a.URL).Send().End(); err != nil || body != "ok" {
+		t.Fatalf("refreshed token not reused: body %q, err %v", body, err)
+	}
+}
+
+func TestReauthSkipsOtherSchemes(t *testing.T) {
+	var seen []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		seen = append(seen, auth)
+		if r.URL.Path == "/bearer" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !strings.HasPrefix(auth, "Digest ") {
+			w.Header().Set("WWW-Authenticate", `Digest realm="r", nonce="n", qop="auth"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer s.Close()
+
+	refreshErr := errors.New("refresh failed")
+	refreshes := 0
+	c := NewHttpClient().SetReauth(func() (string, error) {
+		refreshes++
+		return "", refreshErr
+	})
+	if _, body, err := NewRequest(c).SetUrl(s.URL).SetDigestAuth("u", "p").Send().End(); err != nil || body != "ok" {
+		t.Fatalf("body %q, err %v", body, err)
+	}
+	if refreshes != 0 || len(seen) != 2 || seen[0] != "" || !strings.HasPrefix(seen[1], "Digest ") {
+		t.Fatalf("refreshes %d, Authorization sent %q", refreshes, seen)
+	}
+
+	if _, _, err := NewRequest(c).SetUrl(s.URL + "/bearer").Send().End(); !errors.Is(err, refreshErr) || refreshes != 1 {
+		t.Fatalf("refresh error not returned: %v", err)
+	}
+}
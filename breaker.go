@@ -0,0 +1,94 @@
+package httpc
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen表示熔断器处于打开状态,请求没有被发送
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+const (
+	circuitClosed = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker在连续failureThreshold次失败(连接错误或5xx)后打开,cooldown之后进入半开状态,
+// 半开状态下每次只放行一个探测请求,成功则关闭熔断器,失败则重新打开
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     int
+	failures  int
+	openedAt  time.Time
+	probing   bool
+}
+
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = circuitHalfOpen
+		b.probing = true
+	case circuitHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+func (b *circuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if !failed {
+		b.state = circuitClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.threshold {
+		b.state = circuitOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// 请求被调用方取消时不计入成功或失败,只释放探测名额
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+func (b *circuitBreaker) do(req *http.Request, next RoundTripFunc) (*http.Response, error) {
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+	rsp, err := next(req)
+	if err != nil && req.Context().Err() != nil {
+		b.release()
+	} else {
+		b.record(err != nil || rsp.StatusCode >= http.StatusInternalServerError)
+	}
+	return rsp, err
+}
+
+// SetCircuitBreaker为客户端开启熔断,failureThreshold<=0表示关闭。熔断时请求返回ErrCircuitOpen
+func (this *HttpClient) SetCircuitBreaker(failureThreshold int, cooldown time.Duration) *HttpClient {
+	if failureThreshold <= 0 {
+		this.breaker = nil
+		return this
+	}
+	this.breaker = &circuitBreaker{threshold: failureThreshold, cooldown: cooldown}
+	return this
+}
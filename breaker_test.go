@@ -0,0 +1,41 @@
+package httpc
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	var fail atomic.Bool
+	var hits atomic.Int32
+	fail.Store(true)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer s.Close()
+
+	c := NewHttpClient().SetCircuitBreaker(2, 50*time.Millisecond)
+	for i := 0; i < 2; i++ {
+		NewRequest(c).SetUrl(s.URL).Send().End()
+	}
+	_, _, err := NewRequest(c).SetUrl(s.URL).Send().End()
+	if !errors.Is(err, ErrCircuitOpen) || hits.Load() != 2 {
+		t.Fatalf("expected open circuit after 2 failures, err %v, hits %d", err, hits.Load())
+	}
+
+	fail.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	if _, _, err := NewRequest(c).SetUrl(s.URL).Send().End(); err != nil {
+		t.Fatalf("half-open probe: %v", err)
+	}
+	if _, _, err := NewRequest(c).SetUrl(s.URL).Send().End(); err != nil {
+		t.Fatalf("after recovery: %v", err)
+	}
+}
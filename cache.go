@@ -0,0 +1,146 @@
+package httpc
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type cacheEntry struct {
+	key    string
+	etag   string
+	status string
+	header http.Header
+	body   []byte
+}
+
+// responseCache按url缓存带ETag的GET响应,超过maxEntries时淘汰最久未使用的条目
+type responseCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ll         *list.List
+	items      map[string]*list.Element
+}
+
+func newResponseCache(maxEntries int) *responseCache {
+	return &responseCache{maxEntries: maxEntries, ll: list.New(), items: map[string]*list.Element{}}
+}
+
+func (c *responseCache) get(key string) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		return el.Value.(*cacheEntry)
+	}
+	return nil
+}
+
+func (c *responseCache) add(e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[e.key]; ok {
+		el.Value = e
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[e.key] = c.ll.PushFront(e)
+	for c.ll.Len() > c.maxEntries {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.items, el.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *responseCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.ll.Remove(el)
+		delete(c.items, key)
+	}
+}
+
+// 调用方自己设置了If-None-Match时不使用缓存,由调用方处理304
+func (c *responseCache) do(req *http.Request, next RoundTripFunc) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("If-None-Match") != "" || isNoStore(req.Header) {
+		return next(req)
+	}
+	// 不同Accept-Encoding得到的body编码不同,分开缓存
+	key := req.URL.String() + "\x00" + req.Header.Get("Accept-Encoding")
+	entry := c.get(key)
+	if entry != nil {
+		req.Header.Set("If-None-Match", entry.etag)
+	}
+	rsp, err := next(req)
+	if err != nil {
+		return rsp, err
+	}
+	if entry != nil && rsp.StatusCode == http.StatusNotModified {
+		_ = rsp.Body.Close()
+		return entry.response(rsp), nil
+	}
+	if isNoStore(rsp.Header) {
+		c.remove(key)
+		return rsp, nil
+	}
+	etag := rsp.Header.Get("ETag")
+	if rsp.StatusCode != http.StatusOK || etag == "" {
+		return rsp, nil
+	}
+	// 超过请求的SetMaxResponseBytes时不缓存,剩余部分交给Request按限制读取
+	limit, _ := req.Context().Value(maxResponseKey{}).(int64)
+	body, complete, err := readBodyUpTo(rsp, limit)
+	if err != nil {
+		_ = rsp.Body.Close()
+		return nil, err
+	}
+	if !complete {
+		return rsp, nil
+	}
+	header := rsp.Header.Clone()
+	header.Del("Set-Cookie")
+	c.add(&cacheEntry{key: key, etag: etag, status: rsp.Status, header: header, body: body})
+	return rsp, nil
+}
+
+// 用缓存的内容构造200响应,Set-Cookie使用304响应中的值
+func (e *cacheEntry) response(notModified *http.Response) *http.Response {
+	rsp := *notModified
+	rsp.Status = e.status
+	rsp.StatusCode = http.StatusOK
+	rsp.Header = e.header.Clone()
+	if v := notModified.Header.Values("Set-Cookie"); len(v) > 0 {
+		rsp.Header["Set-Cookie"] = v
+	}
+	rsp.Body = io.NopCloser(bytes.NewReader(e.body))
+	rsp.ContentLength = int64(len(e.body))
+	return &rsp
+}
+
+func isNoStore(h http.Header) bool {
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(d), "no-store") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// EnableCache为GET请求开启内存缓存,最多缓存maxEntries个带ETag的响应,maxEntries<=0表示关闭。
+// 再次请求时自动带上If-None-Match,服务端返回304时使用缓存的body并返回200。
+// 响应带有Cache-Control: no-store时不缓存。缓存的响应会被完整读入内存,不适合用于大文件下载,
+// 超过Request.SetMaxResponseBytes的响应不会被缓存
+func (this *HttpClient) EnableCache(maxEntries int) *HttpClient {
+	if maxEntries <= 0 {
+		this.cache = nil
+		return this
+	}
+	this.cache = newResponseCache(maxEntries)
+	return this
+}
@@ -0,0 +1,70 @@
+package httpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCacheServesBodyOnNotModified(t *testing.T) {
+	hits, notModified := 0, 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.URL.Path == "/no-store" {
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("payload"))
+	}))
+	defer s.Close()
+
+	c := NewHttpClient().EnableCache(2)
+	for i := 0; i < 3; i++ {
+		rsp, body, err := NewRequest(c).SetUrl(s.URL + "/a").Send().End()
+		if err != nil || body != "payload" || rsp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: body %q, err %v", i, body, err)
+		}
+	}
+	if hits != 3 || notModified != 2 {
+		t.Fatalf("hits %d, 304s %d", hits, notModified)
+	}
+
+	for i := 0; i < 2; i++ {
+		NewRequest(c).SetUrl(s.URL + "/no-store").Send().End()
+	}
+	if notModified != 2 {
+		t.Fatalf("no-store response was cached")
+	}
+}
+
+func TestCacheRespectsMaxResponseBytes(t *testing.T) {
+	conditional := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != "" {
+			conditional++
+		}
+		w.Header().Set("ETag", `"big"`)
+		w.Write([]byte("0123456789"))
+	}))
+	defer s.Close()
+
+	c := NewHttpClient().EnableCache(2)
+	for i := 0; i < 2; i++ {
+		_, _, err := NewRequest(c).SetUrl(s.URL).SetMaxResponseBytes(4).Send().End()
+		if err != ErrResponseTooLarge {
+			t.Fatalf("request %d: err %v", i, err)
+		}
+	}
+	if conditional != 0 {
+		t.Fatalf("oversized response was cached")
+	}
+	_, body, err := NewRequest(c).SetUrl(s.URL).Send().End()
+	if err != nil || body != "0123456789" {
+		t.Fatalf("body %q, err %v", body, err)
+	}
+}
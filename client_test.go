@@ -0,0 +1,31 @@
+package httpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDialTimeoutKeepsHTTP2(t *testing.T) {
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	s.EnableHTTP2 = true
+	s.StartTLS()
+	defer s.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(s.Certificate())
+
+	c := NewHttpClient().SetTLSConfig(&tls.Config{RootCAs: pool}).SetDialTimeout(time.Second)
+	if _, body, err := NewRequest(c).SetUrl(s.URL).Send().End(); err != nil || body != "HTTP/2.0" {
+		t.Fatalf("proto %q, err %v", body, err)
+	}
+
+	c = NewHttpClient().SetTLSConfig(&tls.Config{RootCAs: pool}).ForceHTTP1().SetDialTimeout(time.Second)
+	if _, body, err := NewRequest(c).SetUrl(s.URL).Send().End(); err != nil || body != "HTTP/1.1" {
+		t.Fatalf("proto %q, err %v", body, err)
+	}
+}
@@ -0,0 +1,236 @@
+package httpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const snippetSize = 256
+
+// SetJSON序列化v作为json请求体并切换为AsJSON,序列化错误在End时返回
+func (this *Request) SetJSON(v interface{}) *Request {
+	b, err := json.Marshal(v)
+	if err != nil {
+		this.buildErr = fmt.Errorf("encode json: %w", err)
+		return this
+	}
+	this.jsonData = string(b)
+	this.mode = "json"
+	return this
+}
+
+// SetJSONIndent与SetJSON相同,但使用indent缩进输出,便于调试时查看
+func (this *Request) SetJSONIndent(v interface{}, indent string) *Request {
+	b, err := json.MarshalIndent(v, "", indent)
+	if err != nil {
+		this.buildErr = fmt.Errorf("encode json: %w", err)
+		return this
+	}
+	this.jsonData = string(b)
+	this.mode = "json"
+	return this
+}
+
+// SetJSONOmitEmpty与SetJSON相同,但去掉值为零值的字段(null、false、0、""、空数组和空对象),
+// 不需要在结构体上添加omitempty标签,适用于PATCH等只更新部分字段的接口。数组中的元素不会被去掉
+func (this *Request) SetJSONOmitEmpty(v interface{}) *Request {
+	b, err := json.Marshal(v)
+	if err == nil {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		var tree interface{}
+		if err = dec.Decode(&tree); err == nil {
+			b, err = json.Marshal(omitEmpty(tree))
+		}
+	}
+	if err != nil {
+		this.buildErr = fmt.Errorf("encode json: %w", err)
+		return this
+	}
+	this.jsonData = string(b)
+	this.mode = "json"
+	return this
+}
+
+func omitEmpty(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, e := range x {
+			e = omitEmpty(e)
+			if isEmptyJSON(e) {
+				delete(x, k)
+			} else {
+				x[k] = e
+			}
+		}
+	case []interface{}:
+		for i, e := range x {
+			x[i] = omitEmpty(e)
+		}
+	}
+	return v
+}
+
+func isEmptyJSON(v interface{}) bool {
+	switch x := v.(type) {
+	case nil:
+		return true
+	case bool:
+		return !x
+	case string:
+		return x == ""
+	case json.Number:
+		f, err := x.Float64()
+		return err == nil && f == 0
+	case map[string]interface{}:
+		return len(x) == 0
+	case []interface{}:
+		return len(x) == 0
+	}
+	return false
+}
+
+func (this *Request) EndStruct(v interface{}) (*http.Response, error) {
+	rsp, buf, err := this.EndBytes()
+	if err != nil || rsp.StatusCode == http.StatusNotModified {
+		return rsp, err
+	}
+	return rsp, decodeJSON(rsp, buf, v)
+}
+
+// EndStructOrError在状态码符合预期时把json响应解码到success,否则把错误响应的body解码到failure并返回*StatusError,
+// 适用于错误时返回{"error":"...","code":...}的接口。错误响应不是json时failure保持不变
+func (this *Request) EndStructOrError(success, failure interface{}) (*http.Response, error) {
+	rsp, buf, err := this.EndBytes()
+	var se *StatusError
+	if errors.As(err, &se) {
+		if failure != nil && len(buf) > 0 {
+			_ = decodeJSON(rsp, buf, failure)
+		}
+		return rsp, err
+	}
+	if err != nil || rsp.StatusCode == http.StatusNotModified {
+		return rsp, err
+	}
+	return rsp, decodeJSON(rsp, buf, success)
+}
+
+// 204等空body没有内容需要解码,v保持不变
+func decodeJSON(rsp *http.Response, buf []byte, v interface{}) error {
+	if len(buf) == 0 {
+		return nil
+	}
+	if ct := rsp.Header.Get("Content-Type"); ct != "" && !isJSONContentType(ct) {
+		return fmt.Errorf("unexpected content type %q, body: %s", ct, snippet(buf))
+	}
+	if err := json.Unmarshal(buf, v); err != nil {
+		return fmt.Errorf("decode json: %w, body: %s", err, snippet(buf))
+	}
+	return nil
+}
+
+// EndInto把json响应解码为T。Go的方法不能有类型参数,因此以函数的形式提供:
+//
+//	user, rsp, err := httpc.EndInto[User](req.SetUrl(u).Send())
+func EndInto[T any](r *Request) (T, *http.Response, error) {
+	var v T
+	rsp, err := r.EndStruct(&v)
+	return v, rsp, err
+}
+
+// SetXML序列化v作为请求体,Content-Type为application/xml,序列化错误在End时返回
+func (this *Request) SetXML(v interface{}) *Request {
+	b, err := xml.Marshal(v)
+	if err != nil {
+		this.buildErr = fmt.Errorf("encode xml: %w", err)
+		return this
+	}
+	this.body = b
+	this.bodyType = "application/xml; charset=UTF-8"
+	return this
+}
+
+func (this *Request) EndXML(v interface{}) (*http.Response, error) {
+	rsp, buf, err := this.EndBytes()
+	// 304和204等空body没有内容需要解码,v保持不变
+	if err != nil || rsp.StatusCode == http.StatusNotModified || len(buf) == 0 {
+		return rsp, err
+	}
+	if ct := rsp.Header.Get("Content-Type"); ct != "" && !isXMLContentType(ct) {
+		return rsp, fmt.Errorf("unexpected content type %q, body: %s", ct, snippet(buf))
+	}
+	if err := xml.Unmarshal(buf, v); err != nil {
+		return rsp, fmt.Errorf("decode xml: %w, body: %s", err, snippet(buf))
+	}
+	return rsp, nil
+}
+
+// SetProto序列化m作为请求体,Content-Type为application/x-protobuf
+func (this *Request) SetProto(m proto.Message) *Request {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		this.buildErr = fmt.Errorf("encode protobuf: %w", err)
+		return this
+	}
+	this.body = b
+	this.bodyType = "application/x-protobuf"
+	return this
+}
+
+func (this *Request) EndProto(m proto.Message) (*http.Response, error) {
+	rsp, buf, err := this.EndBytes()
+	if err != nil || rsp.StatusCode == http.StatusNotModified {
+		return rsp, err
+	}
+	if ct := rsp.Header.Get("Content-Type"); ct != "" && !isProtoContentType(ct) {
+		return rsp, fmt.Errorf("unexpected content type %q, body: %q", ct, snippet(buf))
+	}
+	if err := proto.Unmarshal(buf, m); err != nil {
+		return rsp, fmt.Errorf("decode protobuf: %w", err)
+	}
+	return rsp, nil
+}
+
+// 兼容application/json、text/json以及application/xxx+json
+func isJSONContentType(ct string) bool {
+	t, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	return t == "application/json" || t == "text/json" || strings.HasSuffix(t, "+json")
+}
+
+func isXMLContentType(ct string) bool {
+	t, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	return t == "application/xml" || t == "text/xml" || strings.HasSuffix(t, "+xml")
+}
+
+func isProtoContentType(ct string) bool {
+	t, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	switch t {
+	case "application/x-protobuf", "application/protobuf", "application/vnd.google.protobuf", "application/octet-stream":
+		return true
+	}
+	return false
+}
+
+func snippet(buf []byte) string {
+	if len(buf) > snippetSize {
+		return string(buf[:snippetSize]) + "..."
+	}
+	return string(buf)
+}
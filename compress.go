@@ -0,0 +1,77 @@
+package httpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/brotli/go/cbrotli"
+)
+
+const defaultBrotliQuality = 5
+
+// SetRequestCompression压缩请求体并设置Content-Encoding,支持gzip和br,空字符串表示不压缩。
+// 压缩结果会先缓存在内存中以便设置正确的Content-Length,SetBodyReader设置的流式body不会被压缩
+func (this *Request) SetRequestCompression(encoding string) *Request {
+	this.compression = encoding
+	return this
+}
+
+// SetCompressionLevel设置请求体的压缩级别,gzip为1-9(0表示不压缩),br为0-11,默认gzip为6、br为5。
+// 较低的级别占用更少CPU,较高的级别压缩率更高
+func (this *Request) SetCompressionLevel(n int) *Request {
+	this.level = n
+	return this
+}
+
+// SetAcceptEncoding声明可以接受的压缩方式,支持gzip、br、deflate、zstd,不带参数表示不压缩(identity)。
+// 未调用时由net/http自动声明gzip并透明解压,此时响应不再带有Content-Encoding;
+// 显式声明后net/http不再自动解压,由EndBytes、EndStream等根据Content-Encoding解压
+func (this *Request) SetAcceptEncoding(values ...string) *Request {
+	if len(values) == 0 {
+		return this.SetHeader("Accept-Encoding", "identity")
+	}
+	for _, v := range values {
+		switch v {
+		case "gzip", "br", "deflate", "zstd", "identity":
+		default:
+			this.buildErr = fmt.Errorf("unsupported accept encoding: %s", v)
+			return this
+		}
+	}
+	return this.SetHeader("Accept-Encoding", strings.Join(values, ", "))
+}
+
+// level为-1时使用默认级别
+func compressBody(body io.Reader, encoding string, level int) (*bytes.Reader, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		gw, err := gzip.NewWriterLevel(&buf, level)
+		if err != nil {
+			return nil, err
+		}
+		w = gw
+	case "br":
+		if level == -1 {
+			level = defaultBrotliQuality
+		}
+		if level < 0 || level > 11 {
+			return nil, fmt.Errorf("brotli: invalid compression level: %d", level)
+		}
+		w = cbrotli.NewWriter(&buf, cbrotli.WriterOptions{Quality: level})
+	default:
+		return nil, fmt.Errorf("unsupported request compression: %s", encoding)
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		_ = w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(buf.Bytes()), nil
+}
@@ -0,0 +1,26 @@
+package httpc
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SetIfNoneMatch发送If-None-Match条件请求,etag未加引号时自动加上。
+// 服务端返回304时不作为错误,可以用NotModified判断
+func (this *Request) SetIfNoneMatch(etag string) *Request {
+	if etag != "*" && !strings.HasPrefix(etag, `"`) && !strings.HasPrefix(etag, `W/"`) {
+		etag = `"` + etag + `"`
+	}
+	return this.SetHeader("If-None-Match", etag)
+}
+
+// SetIfModifiedSince发送If-Modified-Since条件请求,服务端返回304时不作为错误
+func (this *Request) SetIfModifiedSince(t time.Time) *Request {
+	return this.SetHeader("If-Modified-Since", t.UTC().Format(http.TimeFormat))
+}
+
+// NotModified表示服务端对条件请求返回了304,本地缓存的内容仍然有效
+func (this *Request) NotModified() bool {
+	return this.response != nil && this.response.StatusCode == http.StatusNotModified
+}
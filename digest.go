@@ -0,0 +1,162 @@
+package httpc
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+type digestAuth struct {
+	user     string
+	password string
+}
+
+// SetDigestAuth使用HTTP Digest认证(RFC 7616),服务端返回401后根据challenge计算Authorization并重新发送,
+// 支持MD5、SHA-256及其-sess变体,qop只支持auth。
+// multipart请求体会先读入内存以便重新发送,SetBodyReader的流式请求体无法重新发送,此时返回原来的401响应
+func (this *Request) SetDigestAuth(user, password string) *Request {
+	this.digest = &digestAuth{user: user, password: password}
+	return this
+}
+
+// 解析Digest的参数,如realm="a", nonce="b", qop="auth,auth-int",引号中可能包含逗号
+func parseDigestParams(s string) map[string]string {
+	params := map[string]string{}
+	for s != "" {
+		s = strings.TrimLeft(s, " ,")
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " ")
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+			value = b.String()
+			s = s[min(i+1, len(s)):]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+		params[key] = value
+	}
+	return params
+}
+
+func digestHash(algorithm string) func() hash.Hash {
+	switch strings.ToUpper(strings.TrimSuffix(strings.ToLower(algorithm), "-sess")) {
+	case "", "MD5":
+		return md5.New
+	case "SHA-256":
+		return sha256.New
+	}
+	return nil
+}
+
+// 服务端可能同时提供SHA-256和MD5的challenge,优先使用SHA-256
+func (a *digestAuth) challenge(h http.Header) map[string]string {
+	var chosen map[string]string
+	for _, v := range h.Values("WWW-Authenticate") {
+		name, param, _ := strings.Cut(strings.TrimSpace(v), " ")
+		if !strings.EqualFold(name, "Digest") {
+			continue
+		}
+		params := parseDigestParams(param)
+		if digestHash(params["algorithm"]) == nil {
+			continue
+		}
+		if chosen == nil || strings.HasPrefix(strings.ToUpper(params["algorithm"]), "SHA-256") {
+			chosen = params
+		}
+	}
+	return chosen
+}
+
+func (a *digestAuth) authorization(method, uri string, params map[string]string) (string, error) {
+	newHash := digestHash(params["algorithm"])
+	h := func(s string) string {
+		d := newHash()
+		d.Write([]byte(s))
+		return hex.EncodeToString(d.Sum(nil))
+	}
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	cnonce := hex.EncodeToString(b[:])
+	nonce := params["nonce"]
+	const nc = "00000001"
+
+	ha1 := h(a.user + ":" + params["realm"] + ":" + a.password)
+	if strings.HasSuffix(strings.ToLower(params["algorithm"]), "-sess") {
+		ha1 = h(ha1 + ":" + nonce + ":" + cnonce)
+	}
+	ha2 := h(method + ":" + uri)
+
+	qop := ""
+	if v, ok := params["qop"]; ok {
+		for _, q := range strings.Split(v, ",") {
+			if strings.TrimSpace(q) == "auth" {
+				qop = "auth"
+			}
+		}
+		if qop == "" {
+			return "", fmt.Errorf("digest: unsupported qop %q", v)
+		}
+	}
+	var response string
+	if qop != "" {
+		response = h(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+	} else {
+		response = h(ha1 + ":" + nonce + ":" + ha2)
+	}
+
+	s := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`,
+		quoteEscaper.Replace(a.user), quoteEscaper.Replace(params["realm"]), quoteEscaper.Replace(nonce),
+		quoteEscaper.Replace(uri), response)
+	if alg := params["algorithm"]; alg != "" {
+		s += ", algorithm=" + alg
+	}
+	if qop != "" {
+		s += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s"`, qop, nc, cnonce)
+	}
+	if opaque, ok := params["opaque"]; ok {
+		s += fmt.Sprintf(`, opaque="%s"`, quoteEscaper.Replace(opaque))
+	}
+	return s, nil
+}
+
+func (a *digestAuth) handshake(req *http.Request, rsp *http.Response, next RoundTripFunc) (*http.Response, error) {
+	params := a.challenge(rsp.Header)
+	if params == nil {
+		return rsp, nil
+	}
+	final := replayRequest(req)
+	if final == nil {
+		return rsp, nil
+	}
+	auth, err := a.authorization(req.Method, req.URL.RequestURI(), params)
+	if err != nil {
+		return rsp, nil
+	}
+	discardBody(rsp)
+	final.Header.Set("Authorization", auth)
+	return next(final)
+}
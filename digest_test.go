@@ -0,0 +1,61 @@
+package httpc
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDigestAuth(t *testing.T) {
+	for _, alg := range []string{"MD5", "SHA-256", "MD5-sess"} {
+		t.Run(alg, func(t *testing.T) {
+			newHash := md5.New
+			if strings.HasPrefix(alg, "SHA-256") {
+				newHash = sha256.New
+			}
+			h := func(s string) string {
+				var d hash.Hash = newHash()
+				d.Write([]byte(s))
+				return hex.EncodeToString(d.Sum(nil))
+			}
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				auth := r.Header.Get("Authorization")
+				if auth == "" {
+					w.Header().Add("WWW-Authenticate", `Digest realm="test@x", qop="auth,auth-int", algorithm=`+alg+
+						`, nonce="n0nce, with comma", opaque="op"`)
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				p := parseDigestParams(strings.TrimPrefix(auth, "Digest "))
+				ha1 := h("bob:test@x:pw")
+				if strings.HasSuffix(alg, "-sess") {
+					ha1 = h(ha1 + ":" + p["nonce"] + ":" + p["cnonce"])
+				}
+				want := h(ha1 + ":" + p["nonce"] + ":" + p["nc"] + ":" + p["cnonce"] + ":auth:" + h(r.Method+":"+r.URL.RequestURI()))
+				if p["response"] != want || p["opaque"] != "op" || p["nonce"] != "n0nce, with comma" {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				w.Write([]byte("ok"))
+			}))
+			defer s.Close()
+
+			_, body, err := NewRequest(NewHttpClient()).SetUrl(s.URL+"/dir/x?y=1").SetDigestAuth("bob", "pw").Send().End()
+			if err != nil || body != "ok" {
+				t.Fatalf("body %q, err %v", body, err)
+			}
+		})
+	}
+}
+
+func TestParseDigestParams(t *testing.T) {
+	p := parseDigestParams(`realm="a \"b\"", qop="auth,auth-int", algorithm=SHA-256, stale=false`)
+	if p["realm"] != `a "b"` || p["qop"] != "auth,auth-int" || p["algorithm"] != "SHA-256" || p["stale"] != "false" {
+		t.Fatalf("unexpected params %v", p)
+	}
+}
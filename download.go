@@ -0,0 +1,257 @@
+package httpc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrShortDownload表示下载的数据少于Content-Length,通常是连接中断导致的,可以重试
+var ErrShortDownload = errors.New("short download")
+
+// ErrChecksumMismatch表示下载文件的校验和与期望值不一致
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+type saveOptions struct {
+	mode     os.FileMode
+	progress func(written, total int64)
+	append   bool
+	resume   bool
+	sha256   string
+}
+
+// SetResumeFrom设置断点续传的本地文件,Send时该文件已存在则带上Range只请求剩余部分,
+// 同时用文件的修改时间作为If-Range,服务端文件已变化时返回200重新下载,不会拼接到旧文件上。
+// 配合EndFileResume使用,fileName为空表示关闭
+func (this *Request) SetResumeFrom(fileName string) *Request {
+	this.resumeFile = fileName
+	return this
+}
+
+// 每次Send时重新读取本地文件的长度和修改时间
+func (this *Request) statResume() error {
+	this.resumeAt, this.resumeTime = 0, time.Time{}
+	if this.resumeFile == "" {
+		return nil
+	}
+	fi, err := os.Stat(this.resumeFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	this.resumeAt, this.resumeTime = fi.Size(), fi.ModTime()
+	return nil
+}
+
+// 调用方自己设置了Range时不覆盖
+func (this *Request) setResumeHeaders(req *http.Request) {
+	if this.resumeAt <= 0 || req.Header.Get("Range") != "" {
+		return
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", this.resumeAt))
+	req.Header.Set("If-Range", this.resumeTime.UTC().Format(http.TimeFormat))
+}
+
+// EndFile保存的文件权限默认为0644(旧版本为0777),需要其他权限请使用EndFileMode
+func (this *Request) EndFile(savePath, saveFileName string) (*http.Response, error) {
+	return this.EndFileMode(savePath, saveFileName, 0644)
+}
+
+func (this *Request) EndFileMode(savePath, saveFileName string, mode os.FileMode) (*http.Response, error) {
+	if _, err := this.saveFile(savePath, saveFileName, saveOptions{mode: mode}); err != nil {
+		return nil, err
+	}
+	return this.response, nil
+}
+
+// EndFileN与EndFile相同,额外返回写入文件的字节数
+func (this *Request) EndFileN(savePath, saveFileName string) (*http.Response, int64, error) {
+	n, err := this.saveFile(savePath, saveFileName, saveOptions{mode: 0644})
+	if err != nil {
+		return nil, n, err
+	}
+	return this.response, n, nil
+}
+
+// EndFileProgress在写入文件的过程中回调onProgress,total取自Content-Length,未知时为-1
+func (this *Request) EndFileProgress(savePath, saveFileName string, onProgress func(written, total int64)) (*http.Response, error) {
+	if _, err := this.saveFile(savePath, saveFileName, saveOptions{mode: 0644, progress: onProgress}); err != nil {
+		return nil, err
+	}
+	return this.response, nil
+}
+
+// EndFileVerify在写入文件的同时计算SHA-256,与expectedHexSHA256不一致时删除文件并返回ErrChecksumMismatch
+func (this *Request) EndFileVerify(savePath, saveFileName, expectedHexSHA256 string) (*http.Response, error) {
+	if expectedHexSHA256 == "" {
+		return nil, errors.New("expected sha256 is empty")
+	}
+	if _, err := this.saveFile(savePath, saveFileName, saveOptions{mode: 0644, sha256: expectedHexSHA256}); err != nil {
+		return nil, err
+	}
+	return this.response, nil
+}
+
+// EndFileResume断点续传下载:服务端返回206时追加写入,返回200时重新下载整个文件。
+// 写入后把文件的修改时间设置为响应的Last-Modified,下次续传时作为If-Range校验。
+// 用法为SetResumeFrom(文件路径).Send().EndFileResume(...),没有调用Send时会自行设置SetResumeFrom并发送请求
+func (this *Request) EndFileResume(savePath, saveFileName string) (*http.Response, error) {
+	if saveFileName == "" {
+		u, err := url.Parse(this.url)
+		if err != nil {
+			return nil, err
+		}
+		saveFileName = fileNameFromURL(u)
+		if saveFileName == "" {
+			return nil, errors.New("can not get file name from url: " + this.url)
+		}
+	}
+
+	fileName := filepath.Join(savePath, saveFileName)
+	if this.response == nil && this.err == nil {
+		old := this.resumeFile
+		this.SetResumeFrom(fileName).Send(this.sendArgs...)
+		this.resumeFile = old
+	} else if this.resumeAt > 0 && this.resumeFile != fileName {
+		_ = this.response.Body.Close()
+		return nil, fmt.Errorf("resumed from %q, not %q", this.resumeFile, fileName)
+	}
+	if this.err != nil {
+		return nil, this.err
+	}
+
+	offset := this.resumeAt
+	opt := saveOptions{mode: 0644, resume: true}
+	switch this.response.StatusCode {
+	case http.StatusPartialContent:
+		var start int64
+		cr := this.response.Header.Get("Content-Range")
+		if _, err := fmt.Sscanf(cr, "bytes %d-", &start); err != nil || start != offset {
+			_ = this.response.Body.Close()
+			return nil, fmt.Errorf("unexpected Content-Range %q for offset %d", cr, offset)
+		}
+		// 没有续传时从头写入
+		opt.append = offset > 0
+	case http.StatusRequestedRangeNotSatisfiable:
+		// 本地文件已经完整
+		var total int64
+		cr := this.response.Header.Get("Content-Range")
+		_ = this.response.Body.Close()
+		if _, err := fmt.Sscanf(cr, "bytes */%d", &total); err != nil || total != offset {
+			return nil, newStatusError(this.response, nil)
+		}
+		return this.response, nil
+	}
+	if _, err := this.saveFile(savePath, saveFileName, opt); err != nil {
+		return nil, err
+	}
+	return this.response, nil
+}
+
+func (this *Request) saveFile(savePath, saveFileName string, opt saveOptions) (int64, error) {
+	if this.err != nil {
+		return 0, this.err
+	}
+	defer this.response.Body.Close()
+
+	if !this.isExpectedStatus(this.response.StatusCode) {
+		return 0, newStatusError(this.response, nil)
+	}
+	if this.response.StatusCode == http.StatusNotModified {
+		// 304没有body,保留已有的文件
+		return 0, nil
+	}
+
+	if saveFileName == "" {
+		saveFileName = fileNameFromURL(this.request.URL)
+		if saveFileName == "" {
+			return 0, errors.New("can not get file name from url: " + this.request.URL.String())
+		}
+	}
+
+	r, err := this.bodyReader()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if opt.append {
+		flag = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	fileName := filepath.Join(savePath, saveFileName)
+	fd, err := os.OpenFile(fileName, flag, opt.mode)
+	if err != nil {
+		return 0, err
+	}
+	total := this.response.ContentLength
+	if !this.rawBody && this.response.Header.Get("Content-Encoding") != "" {
+		// 解压后的大小未知
+		total = -1
+	}
+	writers := []io.Writer{fd}
+	if opt.progress != nil {
+		writers = append(writers, &progressWriter{total: total, onProgress: opt.progress})
+	}
+	var h hash.Hash
+	if opt.sha256 != "" {
+		h = sha256.New()
+		writers = append(writers, h)
+	}
+	n, err := io.Copy(io.MultiWriter(writers...), r)
+	if err == nil && total >= 0 && n != total {
+		err = fmt.Errorf("%w: got %d of %d bytes", ErrShortDownload, n, total)
+	} else if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = fmt.Errorf("%w: %v", ErrShortDownload, err)
+	}
+	if err == nil && h != nil {
+		if sum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(sum, opt.sha256) {
+			err = fmt.Errorf("%w: expected sha256 %s, got %s", ErrChecksumMismatch, opt.sha256, sum)
+		}
+	}
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	if errors.Is(err, ErrChecksumMismatch) || (errors.Is(err, ErrShortDownload) && !opt.resume) {
+		// 续传时保留已下载的部分,其他情况删除不完整或校验失败的文件
+		_ = os.Remove(fileName)
+	} else if opt.resume {
+		if t, perr := http.ParseTime(this.response.Header.Get("Last-Modified")); perr == nil {
+			_ = os.Chtimes(fileName, time.Now(), t)
+		}
+	}
+	return n, err
+}
+
+type progressWriter struct {
+	written    int64
+	total      int64
+	onProgress func(written, total int64)
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.written += int64(len(p))
+	w.onProgress(w.written, w.total)
+	return len(p), nil
+}
+
+// 只取url路径的最后一段作为文件名,避免../等路径写到保存目录之外
+func fileNameFromURL(u *url.URL) string {
+	name := path.Base(u.Path)
+	if name == "." || name == ".." || name == "/" || strings.ContainsAny(name, `/\:`) {
+		return ""
+	}
+	return name
+}
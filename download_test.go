@@ -0,0 +1,56 @@
+package httpc
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEndFileResume(t *testing.T) {
+	content := []byte("0123456789abcdef")
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	var hits int
+	var ranges []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "f", modTime, bytes.NewReader(content))
+	}))
+	defer s.Close()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "f")
+	c := NewHttpClient()
+
+	check := func(name string, partial []byte, mtime time.Time, wantRange bool, send bool) {
+		t.Helper()
+		hits, ranges = 0, nil
+		if err := os.WriteFile(file, partial, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		req := NewRequest(c).SetUrl(s.URL + "/f")
+		if send {
+			req.SetResumeFrom(file).Send()
+		}
+		if _, err := req.EndFileResume(dir, ""); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, _ := os.ReadFile(file)
+		if hits != 1 || (ranges[0] != "") != wantRange || !bytes.Equal(got, content) {
+			t.Fatalf("%s: hits %d, ranges %q, file %q", name, hits, ranges, got)
+		}
+		if fi, _ := os.Stat(file); !fi.ModTime().Equal(modTime) {
+			t.Fatalf("%s: mtime %v", name, fi.ModTime())
+		}
+	}
+	check("resume", content[:5], modTime, true, true)
+	check("without Send", content[:5], modTime, true, false)
+	// 本地文件的修改时间与服务端不一致,说明文件已变化,重新下载而不是拼接
+	check("changed", []byte("XXXXX"), modTime.Add(-time.Hour), true, true)
+}
@@ -0,0 +1,69 @@
+package httpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"os"
+)
+
+// SetDumpWire为true时把每次发送的完整请求和收到的完整响应(包括请求行、状态行、全部头部和body)
+// 原样输出到SetLogWriter设置的位置,不隐藏Authorization等敏感头部,只应在调试时使用。
+// 响应body会被先读入内存再交给End方法(设置了SetMaxResponseBytes时最多读取该长度),不适合下载大文件时开启
+func (this *Request) SetDumpWire(dump bool) *Request {
+	this.dumpWire = dump
+	return this
+}
+
+func (this *Request) dumpWriter() io.Writer {
+	if this.logWriter == nil {
+		return os.Stdout
+	}
+	return this.logWriter
+}
+
+// body为内存中的请求体,流式请求体无法在不消耗的情况下输出,只输出头部
+func (this *Request) dumpRequest(req *http.Request, body []byte) {
+	w := this.dumpWriter()
+	// 使用新的context,避免DumpRequestOut内部的模拟发送触发trace回调
+	b, err := httputil.DumpRequestOut(req.Clone(context.Background()), false)
+	fmt.Fprintf(w, ">>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>\n")
+	if err != nil {
+		fmt.Fprintf(w, "dump request: %v\n", err)
+		return
+	}
+	w.Write(b)
+	if body != nil {
+		w.Write(body)
+		fmt.Fprintf(w, "\n")
+	} else if req.Body != nil && req.Body != http.NoBody {
+		fmt.Fprintf(w, "<stream body>\n")
+	}
+}
+
+// 读取body后替换为已读部分与剩余部分的拼接,之后的End方法仍然可以读取
+func (this *Request) dumpResponse(rsp *http.Response) {
+	w := this.dumpWriter()
+	fmt.Fprintf(w, "<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<<\n")
+	b, err := httputil.DumpResponse(rsp, false)
+	if err != nil {
+		fmt.Fprintf(w, "dump response: %v\n", err)
+		return
+	}
+	w.Write(b)
+	body, complete, err := readBodyUpTo(rsp, this.maxResponse)
+	if !complete && err == nil {
+		body = body[:this.maxResponse]
+	}
+	w.Write(body)
+	if err != nil {
+		fmt.Fprintf(w, "\ndump response body: %v\n", err)
+		return
+	}
+	if !complete {
+		fmt.Fprintf(w, "...(exceeds %d bytes)", this.maxResponse)
+	}
+	fmt.Fprintf(w, "\n")
+}
@@ -0,0 +1,33 @@
+package httpc
+
+import (
+	"net/http"
+)
+
+// StatusError表示服务端返回了非预期的状态码,Body为解压后的响应body(如果已读取)
+type StatusError struct {
+	Code   int
+	Status string
+	Body   []byte
+}
+
+func (e *StatusError) Error() string {
+	return e.Status
+}
+
+func newStatusError(rsp *http.Response, body []byte) *StatusError {
+	return &StatusError{Code: rsp.StatusCode, Status: rsp.Status, Body: body}
+}
+
+// TransportError表示请求没有得到响应,如连接失败、超时或被取消,可以通过errors.As区分
+type TransportError struct {
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
@@ -1,5 +1,11 @@
 module github.com/Clivebi/httpc
 
-go 1.15
+go 1.23
 
-require github.com/google/brotli/go/cbrotli v0.0.0-20210127140805-63be8a994019
+require (
+	github.com/Azure/go-ntlmssp v0.0.1
+	github.com/google/brotli/go/cbrotli v0.0.0-20210127140805-63be8a994019
+	github.com/klauspost/compress v1.17.11
+	golang.org/x/time v0.8.0
+	google.golang.org/protobuf v1.36.12
+)
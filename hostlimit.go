@@ -0,0 +1,70 @@
+package httpc
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// hostLimiter限制每个host同时进行中的请求数,响应body关闭或请求出错时释放
+type hostLimiter struct {
+	mu    sync.Mutex
+	limit int
+	sems  map[string]chan struct{}
+}
+
+func (h *hostLimiter) acquire(ctx context.Context, host string) (func(), error) {
+	h.mu.Lock()
+	sem, ok := h.sems[host]
+	if !ok {
+		sem = make(chan struct{}, h.limit)
+		h.sems[host] = sem
+	}
+	h.mu.Unlock()
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() { <-sem })
+	}, nil
+}
+
+func (h *hostLimiter) do(req *http.Request, next RoundTripFunc) (*http.Response, error) {
+	release, err := h.acquire(req.Context(), req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := next(req)
+	if err != nil {
+		release()
+		return rsp, err
+	}
+	rsp.Body = &releaseBody{ReadCloser: rsp.Body, release: release}
+	return rsp, nil
+}
+
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
+
+// SetMaxConcurrentPerHost限制对同一个host同时进行中的请求数,n<=0表示不限制。
+// 超出时Send会等待直到其他请求的body被读取关闭或context被取消,因此EndStream、EndRaw返回的body必须关闭
+func (this *HttpClient) SetMaxConcurrentPerHost(n int) *HttpClient {
+	if n <= 0 {
+		this.hostLimit = nil
+		return this
+	}
+	this.hostLimit = &hostLimiter{limit: n, sems: map[string]chan struct{}{}}
+	return this
+}
@@ -0,0 +1,64 @@
+package httpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// RedirectHop是重定向链中的一跳,URL为返回重定向的地址,Location为跳转的目标地址
+type RedirectHop struct {
+	URL        string
+	StatusCode int
+	Location   string
+}
+
+type redirectKey struct{}
+
+type redirectRecorder struct {
+	hops []RedirectHop
+}
+
+// checkRedirect先记录重定向,再交给SetRedirectPolicy设置的策略处理
+func (this *HttpClient) checkRedirect(req *http.Request, via []*http.Request) error {
+	if rec, ok := req.Context().Value(redirectKey{}).(*redirectRecorder); ok && req.Response != nil {
+		rec.hops = append(rec.hops, RedirectHop{
+			URL:        via[len(via)-1].URL.String(),
+			StatusCode: req.Response.StatusCode,
+			Location:   req.URL.String(),
+		})
+	}
+	if this.redirect != nil {
+		return this.redirect(req, via)
+	}
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	return nil
+}
+
+// SetCaptureRedirects开启后记录每一次重定向,通过RedirectChain获取,最终的响应照常返回
+func (this *Request) SetCaptureRedirects(capture bool) *Request {
+	this.captureOn = capture
+	return this
+}
+
+func (this *Request) RedirectChain() []RedirectHop {
+	if this.redirects == nil {
+		return nil
+	}
+	return this.redirects.hops
+}
+
+// FinalURL返回跟随重定向后最终请求的url,没有响应时返回空字符串
+func (this *Request) FinalURL() string {
+	if this.response == nil || this.response.Request == nil {
+		return ""
+	}
+	return this.response.Request.URL.String()
+}
+
+func (this *Request) withRedirectRecorder(ctx context.Context) context.Context {
+	this.redirects = &redirectRecorder{}
+	return context.WithValue(ctx, redirectKey{}, this.redirects)
+}
@@ -1,45 +1,116 @@
 package httpc
 
 import (
+	"bufio"
 	"bytes"
+	"compress/flate"
 	"compress/gzip"
+	"compress/zlib"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"mime/multipart"
 	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"github.com/google/brotli/go/cbrotli"
+	"github.com/klauspost/compress/zstd"
 )
 
+// DefaultUserAgent是未设置User-Agent时使用的默认值,Go默认的Go-http-client会被部分WAF拦截
+const DefaultUserAgent = "httpc/1.0"
+
+type formPart struct {
+	name        string
+	value       string
+	isFile      bool
+	contentType string
+	reader      io.Reader
+}
+
 type Request struct {
-	httpc    *HttpClient
-	request  *http.Request
-	response *http.Response
-	method   string
-	url      string
-	header   map[string]string
-	cookies  *[]*http.Cookie
-	data     url.Values
-	jsonData string
-	fileData map[bool]map[string]string
-	verbose  bool
-	err      error
+	httpc       *HttpClient
+	ctx         context.Context
+	request     *http.Request
+	response    *http.Response
+	method      string
+	url         string
+	host        string
+	header      http.Header
+	cookies     *[]*http.Cookie
+	auth        *url.Userinfo
+	data        url.Values
+	query       url.Values
+	jsonData    string
+	fileData    []formPart
+	multipartFn func(*multipart.Writer) error
+	boundary    string
+	body        []byte
+	bodyType    string
+	reader      io.Reader
+	readerType  string
+	readerSize  int64
+	transfer    string
+	compression string
+	level       int
+	verbose     bool
+	rawBody     bool
+	logWriter   io.Writer
+	logRedact   []string
+	logBody     int
+	maxResponse int64
+	dumpWire    bool
+	expect      bool
+	ntlm        *ntlmAuth
+	digest      *digestAuth
+	expected    []int
+	timeout     time.Duration
+	retry       RetryPolicy
+	retryUnsafe bool
+	beforeSend  []func(*http.Request) error
+	signer      func(*http.Request, []byte) error
+	upload      func(written, total int64)
+	mode        string
+	sendArgs    []interface{}
+	resumeFile  string
+	resumeAt    int64
+	resumeTime  time.Time
+	duration    time.Duration
+	sent        atomic.Int64
+	received    atomic.Int64
+	traceOn     bool
+	trace       *tracer
+	captureOn   bool
+	redirects   *redirectRecorder
+	buildErr    error
+	err         error
 }
 
 func NewRequest(client *HttpClient) *Request {
 	return &Request{
-		httpc:    client,
-		method:   "GET",
-		header:   make(map[string]string),
-		cookies:  new([]*http.Cookie),
-		data:     url.Values{},
-		fileData: make(map[bool]map[string]string),
+		httpc:   client,
+		ctx:     context.Background(),
+		method:  "GET",
+		header:  http.Header{},
+		cookies: new([]*http.Cookie),
+		data:    url.Values{},
+		query:   url.Values{},
+		mode:    "url",
+		logBody: 1024,
+		level:   -1,
 	}
 }
 
@@ -53,189 +124,1057 @@ func (this *Request) SetUrl(url string) *Request {
 	return this
 }
 
+// SetHost设置发送的Host请求头,连接仍然使用url中的地址。Go会忽略SetHeader("Host", ...),需要使用该方法
+func (this *Request) SetHost(host string) *Request {
+	this.host = host
+	return this
+}
+
+func (this *Request) SetContext(ctx context.Context) *Request {
+	this.ctx = ctx
+	return this
+}
+
+// SetTimeout设置单个请求的超时时间(包括读取body),设置后该请求不再使用HttpClient.SetTimeout的超时,
+// 可以比客户端的超时更长或更短
+func (this *Request) SetTimeout(d time.Duration) *Request {
+	this.timeout = d
+	return this
+}
+
 func (this *Request) SetHeader(name, value string) *Request {
-	this.header[name] = value
+	this.header.Set(name, value)
 	return this
 }
 
+// SetHeaders批量设置请求头,只覆盖map中出现的请求头
+func (this *Request) SetHeaders(h map[string]string) *Request {
+	for k, v := range h {
+		this.header.Set(k, v)
+	}
+	return this
+}
+
+func (this *Request) SetHeadersFromHTTP(h http.Header) *Request {
+	for k, v := range h {
+		this.header[textproto.CanonicalMIMEHeaderKey(k)] = append([]string(nil), v...)
+	}
+	return this
+}
+
+// AddHeader追加请求头,同名请求头可以有多个值
+func (this *Request) AddHeader(name, value string) *Request {
+	this.header.Add(name, value)
+	return this
+}
+
+// SetBasicAuth在请求创建后设置,通过SetHeader显式设置的Authorization优先
+func (this *Request) SetBasicAuth(username, password string) *Request {
+	this.auth = url.UserPassword(username, password)
+	return this
+}
+
+// SetUserAgent优先于HttpClient.SetDefaultHeader设置的User-Agent和默认的DefaultUserAgent
+func (this *Request) SetUserAgent(s string) *Request {
+	return this.SetHeader("User-Agent", s)
+}
+
+func (this *Request) SetBearerToken(token string) *Request {
+	return this.SetHeader("Authorization", "Bearer "+token)
+}
+
 func (this *Request) SetCookies(cookies *[]*http.Cookie) *Request {
 	this.cookies = cookies
 	return this
 }
 
+// SetCookieHeader解析浏览器中复制的"a=1; b=2"格式的Cookie头,替换SetCookies设置的cookie,格式错误时在End时返回
+func (this *Request) SetCookieHeader(s string) *Request {
+	cookies, err := http.ParseCookie(s)
+	if err != nil {
+		this.buildErr = fmt.Errorf("parse cookie: %w", err)
+		return this
+	}
+	this.cookies = &cookies
+	return this
+}
+
 func (this *Request) SetVerbose(d bool) *Request {
 	this.verbose = d
 	return this
 }
 
+func (this *Request) SetExpectedStatus(codes ...int) *Request {
+	this.expected = codes
+	return this
+}
+
+// SetLogWriter设置SetVerbose(true)时日志的输出位置,默认为标准输出
+func (this *Request) SetLogWriter(w io.Writer) *Request {
+	this.logWriter = w
+	return this
+}
+
+// SetLogRedact在默认的Authorization、Cookie、Set-Cookie、Proxy-Authorization之外,
+// 添加日志中需要隐藏值的请求头
+func (this *Request) SetLogRedact(names ...string) *Request {
+	this.logRedact = append(this.logRedact, names...)
+	return this
+}
+
+// SetLogBodyLimit设置verbose日志中响应body最多输出的字节数,默认1024,0表示不输出body
+func (this *Request) SetLogBodyLimit(n int) *Request {
+	this.logBody = n
+	return this
+}
+
+// SetRawBody为true时EndBytes、EndStream等不再根据Content-Encoding解压,原样返回body。
+// 未设置Accept-Encoding时会声明gzip,避免net/http自动解压
+func (this *Request) SetRawBody(raw bool) *Request {
+	this.rawBody = raw
+	return this
+}
+
+// AsForm、AsJSON、AsMultipart设置请求体的编码方式,Send()不带参数时使用,默认为AsForm
+func (this *Request) AsForm() *Request {
+	this.mode = "url"
+	return this
+}
+
+func (this *Request) AsJSON() *Request {
+	this.mode = "json"
+	return this
+}
+
+func (this *Request) AsMultipart() *Request {
+	this.mode = "file"
+	return this
+}
+
 func (this *Request) SetData(name, value string) *Request {
 	this.data.Set(name, value)
 	return this
 }
 
+// AddData追加表单字段,同名字段会重复发送,如tag=a&tag=b
+func (this *Request) AddData(name, value string) *Request {
+	this.data.Add(name, value)
+	return this
+}
+
+// SetQuery设置url查询参数,与url中已有的参数合并,同名参数以SetQuery为准
+func (this *Request) SetQuery(name, value string) *Request {
+	this.query.Set(name, value)
+	return this
+}
+
 func (this *Request) SetJsonData(s string) *Request {
 	this.jsonData = s
 	return this
 }
 
+// SetFilePart上传文件并指定该part的Content-Type,contentType为空时根据文件扩展名判断
+func (this *Request) SetFilePart(field, filePath, contentType string) *Request {
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(filePath))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	this.fileData = append(this.fileData, formPart{name: field, value: filePath, isFile: true, contentType: contentType})
+	return this
+}
+
+// SetMultipart设置自定义写入multipart请求体的函数并切换为AsMultipart,f在SetData、SetFileData的字段之后调用,
+// 不需要关闭w。f返回的错误在End时返回
+func (this *Request) SetMultipart(f func(w *multipart.Writer) error) *Request {
+	this.multipartFn = f
+	this.mode = "file"
+	return this
+}
+
+// SetMultipartBoundary使用固定的multipart分隔符代替随机生成的,用于对请求体签名等需要请求体可重现的场景。
+// 分隔符需要符合RFC 2046(1到70个字符,只能包含字母、数字和部分符号,不能以空格结尾),否则在End时返回错误
+func (this *Request) SetMultipartBoundary(boundary string) *Request {
+	if err := multipart.NewWriter(io.Discard).SetBoundary(boundary); err != nil {
+		this.buildErr = fmt.Errorf("multipart boundary %q: %w", boundary, err)
+		return this
+	}
+	this.boundary = boundary
+	return this
+}
+
+// SetFileReader从r读取文件内容上传,filename为multipart中的文件名,需配合AsMultipart使用。
+// r只会被读取一次,再次Send时不会重新上传其内容
+func (this *Request) SetFileReader(field, filename string, r io.Reader) *Request {
+	this.fileData = append(this.fileData, formPart{name: field, value: filename, isFile: true, reader: r})
+	return this
+}
+
+// BeforeSend添加在请求发送前调用的函数,可用于修改请求头或对请求签名,返回错误时不再发送请求。
+// 重试时每次发送前都会调用
+func (this *Request) BeforeSend(f func(*http.Request) error) *Request {
+	this.beforeSend = append(this.beforeSend, f)
+	return this
+}
+
+// SetSigner设置请求签名函数,在BeforeSend之后、发送之前调用,body为实际发送的请求体(压缩后),
+// 使用SetBodyReader时body为nil,multipart请求体会先读入内存。重试时每次发送前都会重新签名
+func (this *Request) SetSigner(f func(req *http.Request, body []byte) error) *Request {
+	this.signer = f
+	return this
+}
+
+// SetUploadProgress在发送请求体的过程中回调f,total为请求体长度,未知时为-1
+func (this *Request) SetUploadProgress(f func(written, total int64)) *Request {
+	this.upload = f
+	return this
+}
+
+// SetBody直接使用b作为请求体,优先于SetData、SetJSON等设置
+func (this *Request) SetBody(b []byte, contentType string) *Request {
+	this.body = b
+	this.bodyType = contentType
+	return this
+}
+
+// SetBodyReader直接使用r作为请求体,优先于其他body设置。r只能被读取一次,因此不会重试
+func (this *Request) SetBodyReader(r io.Reader, contentType string) *Request {
+	this.reader = r
+	this.readerType = contentType
+	this.readerSize = -1
+	return this
+}
+
+// SetBodyReaderN与SetBodyReader相同,size为r的长度,用于设置Content-Length,避免使用chunked编码上传
+func (this *Request) SetBodyReaderN(r io.Reader, size int64, contentType string) *Request {
+	this.reader = r
+	this.readerType = contentType
+	this.readerSize = size
+	return this
+}
+
+// SetExpectContinue为true时发送Expect: 100-continue,服务端确认后才发送请求体,
+// 服务端可以在上传大文件前直接返回401、413等错误。服务端返回417时去掉Expect重新发送。
+// 等待确认的时间由HttpClient.SetExpectContinueTimeout设置
+func (this *Request) SetExpectContinue(expect bool) *Request {
+	this.expect = expect
+	return this
+}
+
+// SetChunked强制请求体使用chunked编码(true)或Content-Length(false)发送,默认长度已知时使用Content-Length。
+// 强制使用Content-Length时长度未知的请求体(SetBodyReader、multipart)会先完整读入内存
+func (this *Request) SetChunked(chunked bool) *Request {
+	if chunked {
+		this.transfer = "chunked"
+	} else {
+		this.transfer = "length"
+	}
+	return this
+}
+
 func (this *Request) SetFileData(name, value string, isFile bool) *Request {
-	this.fileData[isFile] = map[string]string{name: value}
+	this.fileData = append(this.fileData, formPart{name: name, value: value, isFile: isFile})
 	return this
 }
 
+// Request可以重复Send,每次Send会关闭上一次的响应并重置请求结果,但Request不是并发安全的
 func (this *Request) Send(a ...interface{}) *Request {
-	var err error
+	if this.response != nil {
+		_ = this.response.Body.Close()
+	}
+	this.request, this.response, this.err = nil, nil, nil
+	this.duration = 0
+	this.sent.Store(0)
+	this.received.Store(0)
+	this.trace = nil
+	this.redirects = nil
+	this.sendArgs = a
+	if this.buildErr != nil {
+		this.err = this.buildErr
+		return this
+	}
+	if err := this.statResume(); err != nil {
+		this.err = err
+		return this
+	}
 
-	if len(a) == 0 || a[0] == "url" {
-		this.request, err = http.NewRequest(this.method, this.url, strings.NewReader(this.data.Encode()))
-		defer this.log("url")
-		if err != nil {
-			this.err = err
-			return this
+	t := this.mode
+	if this.reader != nil {
+		t = "reader"
+	} else if this.body != nil {
+		t = "body"
+	} else if len(a) > 0 {
+		// 兼容旧的Send("url")、Send("json"),其他参数均表示multipart
+		if a[0] == "url" || a[0] == "json" {
+			t = a[0].(string)
+		} else {
+			t = "file"
 		}
+	}
+
+	defer this.log(t)
 
-		if this.method == "POST" {
-			if len(this.request.Header.Get("Content-Type")) == 0 {
-				this.request.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=UTF-8")
+	reqURL, err := this.buildURL(t)
+	if err != nil {
+		this.err = err
+		return this
+	}
+	body, contentType, err := this.buildBody(t)
+	if err == nil && this.compression != "" && t != "reader" && !isEmptyBody(body) {
+		body, err = compressBody(body, this.compression, this.level)
+	}
+	if err == nil && (this.transfer == "length" || t == "file" && this.needReplay()) {
+		body, err = this.bufferBody(t, body)
+	}
+	if err != nil {
+		this.err = err
+		return this
+	}
+
+	ctx := this.ctx
+	if this.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.WithValue(ctx, ownDeadlineKey{}, true), this.timeout)
+		defer func() {
+			// 超时context需要在body读取完关闭时才能取消
+			if this.err == nil && this.response != nil {
+				this.response.Body = &cancelBody{ReadCloser: this.response.Body, cancel: cancel}
+			} else {
+				cancel()
 			}
+		}()
+	}
+
+	var bodyBytes []byte
+	if r, ok := body.(*bytes.Reader); ok && (this.signer != nil || this.dumpWire) {
+		bodyBytes = make([]byte, r.Size())
+		_, _ = r.ReadAt(bodyBytes, 0)
+	}
+
+	expect := this.expect
+	start := time.Now()
+	defer func() {
+		this.duration = time.Since(start)
+	}()
+	for attempt := 1; ; attempt++ {
+		this.request, err = this.newHttpRequest(ctx, reqURL, body, contentType)
+		if err == nil && expect && this.request.Body != nil && this.request.Body != http.NoBody {
+			this.request.Header.Set("Expect", "100-continue")
+		}
+		for _, f := range this.beforeSend {
+			if err != nil {
+				break
+			}
+			err = f(this.request)
+		}
+		if err == nil && this.signer != nil {
+			err = this.signer(this.request, bodyBytes)
 		}
-	} else if a[0] == "json" {
-		this.request, err = http.NewRequest(this.method, this.url, strings.NewReader(this.jsonData))
-		defer this.log("json")
 		if err != nil {
 			this.err = err
 			return this
 		}
-	} else {
-		bodyBuf := &bytes.Buffer{}
-		bodyWriter := multipart.NewWriter(bodyBuf)
-		for h, m := range this.fileData {
-			for k, v := range m {
-				if h {
-					fd, err := os.Open(v)
-					if err != nil {
-						this.err = err
-						return this
-					}
-					fileWriter, _ := bodyWriter.CreateFormFile(k, filepath.Base(v))
-					_, _ = io.Copy(fileWriter, fd)
-					fd.Close()
-				} else {
-					_ = bodyWriter.WriteField(k, v)
-				}
+
+		if this.dumpWire {
+			this.dumpRequest(this.request, bodyBytes)
+		}
+		this.response, err = this.do(this.request)
+		if this.dumpWire && err == nil {
+			this.dumpResponse(this.response)
+		}
+		if expect && err == nil && this.response.StatusCode == http.StatusExpectationFailed {
+			// 服务端不支持100-continue时去掉Expect重新发送,流式body可能已被读取,不重新发送
+			if rewind, ok := body.(*bytes.Reader); ok {
+				_, _ = io.Copy(ioutil.Discard, this.response.Body)
+				_ = this.response.Body.Close()
+				_, _ = rewind.Seek(0, io.SeekStart)
+				expect = false
+				continue
+			}
+		}
+		// 只有内存中的body可以重新读取,流式body不重试
+		rewind, ok := body.(*bytes.Reader)
+		if this.retry == nil || !ok || ctx.Err() != nil || !this.canRetryMethod() {
+			break
+		}
+		retry, wait := this.retry(attempt, this.response, err)
+		if !retry {
+			break
+		}
+		if d, ok := retryAfter(this.response); ok {
+			wait = d
+		}
+		if this.response != nil {
+			_, _ = io.Copy(ioutil.Discard, this.response.Body)
+			_ = this.response.Body.Close()
+			this.response = nil
+		}
+		if err = sleepContext(ctx, wait); err != nil {
+			break
+		}
+		_, _ = rewind.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil && !errors.Is(err, ctxErr) {
+			err = fmt.Errorf("%v: %w", err, ctxErr)
+		}
+		this.err = &TransportError{Err: err}
+		return this
+	}
+	this.response.Body = &countingBody{ReadCloser: this.response.Body, n: &this.received}
+
+	return this
+}
+
+func (this *Request) buildBody(t string) (io.Reader, string, error) {
+	switch t {
+	case "url":
+		// body类型只取决于是否有数据,PUT、PATCH、DELETE等方法与POST相同。
+		// 没有数据时不发送body,除POST外也不设置Content-Type,DELETE、GET等不会带上多余的表单头。
+		// GET、HEAD的数据已经由buildURL放到查询参数中
+		if len(this.data) == 0 || this.dataInQuery(t) {
+			if this.method == "POST" {
+				return bytes.NewReader(nil), "application/x-www-form-urlencoded; charset=UTF-8", nil
+			}
+			return bytes.NewReader(nil), "", nil
+		}
+		return bytes.NewReader([]byte(this.data.Encode())), "application/x-www-form-urlencoded; charset=UTF-8", nil
+	case "json":
+		return bytes.NewReader([]byte(this.jsonData)), "application/json; charset=UTF-8", nil
+	case "body":
+		return bytes.NewReader(this.body), this.bodyType, nil
+	case "reader":
+		return this.reader, this.readerType, nil
+	}
+
+	// 文件不存在等错误在发送前返回,而不是在上传过程中才出错
+	for _, p := range this.fileData {
+		if p.isFile && p.reader == nil {
+			if _, err := os.Stat(p.value); err != nil {
+				return nil, "", err
 			}
 		}
+	}
+	// multipart请求体通过管道边写边发送,不会把整个文件读入内存。
+	// 设置了签名、重试或认证时由Send通过bufferBody读入内存,以便重新发送
+	pr, pw := io.Pipe()
+	bodyWriter := multipart.NewWriter(pw)
+	if this.boundary != "" {
+		if err := bodyWriter.SetBoundary(this.boundary); err != nil {
+			return nil, "", err
+		}
+	}
+	body := &multipartBody{PipeReader: pr, write: func() {
+		err := this.writeMultipart(bodyWriter)
+		if err == nil {
+			err = bodyWriter.Close()
+		}
+		pw.CloseWithError(err)
+	}}
+	return body, bodyWriter.FormDataContentType(), nil
+}
+
+func isEmptyBody(body io.Reader) bool {
+	r, ok := body.(*bytes.Reader)
+	return ok && r.Len() == 0
+}
 
-		contentType := bodyWriter.FormDataContentType()
-		_ = bodyWriter.Close()
-		this.request, err = http.NewRequest(this.method, this.url, ioutil.NopCloser(bodyBuf))
-		defer this.log("file")
+// 签名、重试以及401后重新认证都需要完整的请求体,此时multipart请求体不再流式发送
+func (this *Request) needReplay() bool {
+	return this.signer != nil || this.retry != nil || this.httpc.reauth != nil || this.ntlm != nil || this.digest != nil
+}
+
+// 长度未知的请求体读入内存,以便设置Content-Length
+func (this *Request) bufferBody(t string, body io.Reader) (io.Reader, error) {
+	if _, ok := body.(*bytes.Reader); ok || t == "reader" && this.readerSize >= 0 {
+		return body, nil
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(b), nil
+}
+
+// multipartBody在第一次读取时才开始写入,请求没有发送时不会留下阻塞的goroutine。
+// 写入出错时读取方得到该错误,发送被中止
+type multipartBody struct {
+	*io.PipeReader
+	once  sync.Once
+	write func()
+}
+
+func (b *multipartBody) Read(p []byte) (int, error) {
+	b.once.Do(func() {
+		go b.write()
+	})
+	return b.PipeReader.Read(p)
+}
+
+// SetData设置的普通字段和SetFileData设置的字段、文件写在同一个multipart请求中
+func (this *Request) writeMultipart(bodyWriter *multipart.Writer) error {
+	keys := make([]string, 0, len(this.data))
+	for k := range this.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range this.data[k] {
+			if err := bodyWriter.WriteField(k, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, p := range this.fileData {
+		if !p.isFile {
+			if err := bodyWriter.WriteField(p.name, p.value); err != nil {
+				return err
+			}
+			continue
+		}
+		if p.reader != nil {
+			fileWriter, err := createFilePart(bodyWriter, p.name, p.value, p.contentType)
+			if err == nil {
+				_, err = io.Copy(fileWriter, p.reader)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		fd, err := os.Open(p.value)
 		if err != nil {
-			this.err = err
-			return this
+			return err
+		}
+		fileWriter, err := createFilePart(bodyWriter, p.name, filepath.Base(p.value), p.contentType)
+		if err == nil {
+			_, err = io.Copy(fileWriter, fd)
+		}
+		fd.Close()
+		if err != nil {
+			return err
 		}
+	}
+	if this.multipartFn != nil {
+		return this.multipartFn(bodyWriter)
+	}
+	return nil
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// contentType为空时与CreateFormFile一致,使用application/octet-stream
+func createFilePart(w *multipart.Writer, field, filename, contentType string) (io.Writer, error) {
+	if contentType == "" {
+		return w.CreateFormFile(field, filename)
+	}
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+		quoteEscaper.Replace(field), quoteEscaper.Replace(filename)))
+	h.Set("Content-Type", contentType)
+	return w.CreatePart(h)
+}
+
+// GET、HEAD请求不发送body,SetData的数据作为查询参数发送
+func (this *Request) dataInQuery(t string) bool {
+	return t == "url" && (this.method == http.MethodGet || this.method == http.MethodHead)
+}
 
-		this.request.Header.Set("Content-Type", contentType)
+// 合并url中已有的查询参数、SetQuery的参数以及GET、HEAD请求的表单数据,同名参数以SetQuery为准
+func (this *Request) buildURL(t string) (string, error) {
+	query := this.query
+	if this.dataInQuery(t) && len(this.data) > 0 {
+		query = url.Values{}
+		for k, v := range this.data {
+			query[k] = v
+		}
+		for k, v := range this.query {
+			query[k] = v
+		}
+	}
+	if len(query) == 0 {
+		return this.url, nil
+	}
+	u, err := url.Parse(this.url)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	for k, v := range query {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// 每次重试都需要重新生成http.Request
+func (this *Request) newHttpRequest(ctx context.Context, reqURL string, body io.Reader, contentType string) (*http.Request, error) {
+	if this.traceOn {
+		this.trace = &tracer{}
+		ctx = httptrace.WithClientTrace(ctx, this.trace.clientTrace())
+	}
+	if this.captureOn {
+		ctx = this.withRedirectRecorder(ctx)
+	}
+	if this.maxResponse > 0 {
+		ctx = context.WithValue(ctx, maxResponseKey{}, this.maxResponse)
+	}
+	useReauth := this.httpc.reauth != nil && this.ntlm == nil && this.digest == nil
+	if this.httpc.reauth != nil && !useReauth {
+		ctx = context.WithValue(ctx, noReauthKey{}, true)
+	}
+	req, err := http.NewRequestWithContext(ctx, this.method, reqURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if this.host != "" {
+		req.Host = this.host
+	}
+	if this.reader != nil && this.readerSize >= 0 {
+		req.ContentLength = this.readerSize
+		if this.readerSize == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	if this.transfer == "chunked" && req.Body != nil && req.Body != http.NoBody {
+		req.ContentLength = -1
+	}
+	for k, v := range this.httpc.header {
+		req.Header[k] = append([]string(nil), v...)
+	}
+	if useReauth {
+		if token := this.httpc.reauth.bearer(req.URL.Host); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	// 空body(如GET、DELETE)没有被压缩,不设置Content-Encoding
+	if this.compression != "" && this.reader == nil && req.Body != http.NoBody {
+		req.Header.Set("Content-Encoding", this.compression)
+	}
+	if this.auth != nil {
+		password, _ := this.auth.Password()
+		req.SetBasicAuth(this.auth.Username(), password)
 	}
 	for k, v := range this.header {
-		this.request.Header.Set(k, v)
+		req.Header[k] = append([]string(nil), v...)
+	}
+	this.setResumeHeaders(req)
+	if _, ok := req.Header["User-Agent"]; !ok {
+		req.Header.Set("User-Agent", DefaultUserAgent)
+	}
+	if this.rawBody && req.Header.Get("Accept-Encoding") == "" && req.Header.Get("Range") == "" {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = &countingBody{ReadCloser: req.Body, n: &this.sent}
+	}
+	if this.upload != nil && req.Body != nil && req.Body != http.NoBody {
+		total := req.ContentLength
+		if total == 0 {
+			total = -1
+		}
+		req.Body = &progressReader{ReadCloser: req.Body, total: total, onProgress: this.upload}
 	}
 
 	for _, v := range *this.cookies {
 		s := fmt.Sprintf("%s=%s", v.Name, v.Value)
-		if c := this.request.Header.Get("Cookie"); c != "" {
-			this.request.Header.Set("Cookie", c+"; "+s)
+		if c := req.Header.Get("Cookie"); c != "" {
+			req.Header.Set("Cookie", c+"; "+s)
 		} else {
-			this.request.Header.Set("Cookie", s)
+			req.Header.Set("Cookie", s)
 		}
 	}
-
-	this.response, err = this.httpc.client.Do(this.request)
-	if err != nil {
-		this.err = err
-		return this
-	}
-
-	return this
+	return req, nil
 }
 
 func (this *Request) log(t string) {
 	if this.verbose == true {
-		fmt.Printf("-------------------------------------------------------------------\n")
-		fmt.Printf("Request: %s %s\nHeader: %v\nCookies: %v\n", this.method, this.url, this.request.Header, this.request.Cookies())
+		w := this.logWriter
+		if w == nil {
+			w = os.Stdout
+		}
+		fmt.Fprintf(w, "-------------------------------------------------------------------\n")
+		fmt.Fprintf(w, "Request: %s %s\n", this.method, this.url)
+		// 请求构建失败时request为nil,只输出错误
+		if this.request == nil {
+			fmt.Fprintf(w, "Error: %v\n", this.err)
+			fmt.Fprintf(w, "-------------------------------------------------------------------\n")
+			return
+		}
+		fmt.Fprintf(w, "Header: %v\nCookies: %v\n", this.redactHeader(this.request.Header), this.redactCookies(this.request.Cookies()))
 		if t == "url" {
-			fmt.Printf("Body: %v\n", this.data)
+			fmt.Fprintf(w, "Body: %v\n", this.data)
 		} else if t == "json" {
-			fmt.Printf("Body: %v\n", this.jsonData)
+			fmt.Fprintf(w, "Body: %v\n", this.jsonData)
+		} else if t == "body" && utf8.Valid(this.body) {
+			fmt.Fprintf(w, "Body: %s\n", this.body)
+		} else if t == "body" {
+			fmt.Fprintf(w, "Body: <%d bytes %s>\n", len(this.body), this.bodyType)
+		} else if t == "reader" {
+			fmt.Fprintf(w, "Body: <stream %s>\n", this.readerType)
+		} else {
+			fmt.Fprintf(w, "Body: %v\n", this.fileData)
+		}
+		if this.err != nil {
+			fmt.Fprintf(w, "Error: %v\n", this.err)
+		}
+		fmt.Fprintf(w, "-------------------------------------------------------------------\n")
+	}
+}
+
+var redactHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization"}
+
+func (this *Request) isRedacted(name string) bool {
+	for _, v := range redactHeaders {
+		if strings.EqualFold(v, name) {
+			return true
+		}
+	}
+	for _, v := range this.logRedact {
+		if strings.EqualFold(v, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *Request) redactHeader(h http.Header) http.Header {
+	out := h.Clone()
+	for k, v := range out {
+		if this.isRedacted(k) {
+			for i := range v {
+				v[i] = "***"
+			}
+		}
+	}
+	return out
+}
+
+func (this *Request) redactCookies(cookies []*http.Cookie) []string {
+	out := make([]string, 0, len(cookies))
+	for _, c := range cookies {
+		if this.isRedacted("Cookie") {
+			out = append(out, c.Name+"=***")
 		} else {
-			fmt.Printf("Body: %v\n", this.fileData)
+			out = append(out, c.Name+"="+c.Value)
+		}
+	}
+	return out
+}
+
+func (this *Request) logResponse(body []byte) {
+	if this.verbose == true {
+		w := this.logWriter
+		if w == nil {
+			w = os.Stdout
 		}
-		fmt.Printf("-------------------------------------------------------------------\n")
+		fmt.Fprintf(w, "-------------------------------------------------------------------\n")
+		fmt.Fprintf(w, "Response: %s %s\nHeader: %v\n", this.response.Proto, this.response.Status, this.redactHeader(this.response.Header))
+		if this.logBody > 0 {
+			if len(body) > this.logBody {
+				fmt.Fprintf(w, "Body: %s...(%d bytes)\n", body[:this.logBody], len(body))
+			} else {
+				fmt.Fprintf(w, "Body: %s\n", body)
+			}
+		}
+		fmt.Fprintf(w, "-------------------------------------------------------------------\n")
+	}
+}
+
+// 2xx状态码总是视为成功,SetExpectedStatus可以额外放行其他状态码(如3xx、404)
+func (this *Request) isExpectedStatus(code int) bool {
+	// 304只会出现在条件请求中,表示缓存有效而不是请求失败
+	if code >= 200 && code < 300 || code == http.StatusNotModified {
+		return true
+	}
+	for _, c := range this.expected {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// BytesSent返回Send发送的请求体字节数(压缩后),重试时累计每次发送的字节数,不包括请求行和请求头
+func (this *Request) BytesSent() int64 {
+	return this.sent.Load()
+}
+
+// BytesReceived返回已经读取的响应body字节数(解压前),不包括状态行和响应头。
+// 未设置Accept-Encoding时Go会自动解压gzip,此时统计的是解压后的字节数
+func (this *Request) BytesReceived() int64 {
+	return this.received.Load()
+}
+
+// Duration返回Send从发出请求到收到响应头的耗时,包括重试和等待的时间,不包括读取body
+func (this *Request) Duration() time.Duration {
+	return this.duration
+}
+
+// Cookies返回响应中Set-Cookie设置的cookie,没有响应时返回nil
+func (this *Request) Cookies() []*http.Cookie {
+	if this.response == nil {
+		return nil
+	}
+	return this.response.Cookies()
+}
+
+// ResponseHeader返回响应头中name的值,没有响应时返回空字符串
+func (this *Request) ResponseHeader(name string) string {
+	if this.response == nil {
+		return ""
+	}
+	return this.response.Header.Get(name)
+}
+
+// ResponseHeaders返回全部响应头,没有响应时返回nil
+func (this *Request) ResponseHeaders() http.Header {
+	if this.response == nil {
+		return nil
 	}
+	return this.response.Header
 }
 
 func (this *Request) End() (*http.Response, string, error) {
 	rsp, buf, err := this.EndBytes()
-	if err != nil {
-		return rsp, "", err
-	}
-	return rsp, string(buf), nil
+	return rsp, string(buf), err
 }
 
 func (this *Request) EndBytes() (*http.Response, []byte, error) {
-	var buf []byte
-	var err error
 	if this.err != nil {
-		return nil, []byte(""), errors.New(this.err.Error())
+		return nil, []byte(""), this.err
 	}
 
-	if this.response.StatusCode != http.StatusOK {
-		return this.response, nil, errors.New(this.response.Status)
-	}
-	defer this.response.Body.Close()
-	switch this.response.Header.Get("Content-Encoding") {
-	case "gzip":
-		r, err := gzip.NewReader(this.response.Body)
-		if err != nil {
-			break
-		}
-		defer r.Close()
-		buf, err = ioutil.ReadAll(r)
-	case "br":
-		r := cbrotli.NewReader(this.response.Body)
-		defer r.Close()
-		buf, err = ioutil.ReadAll(r)
-	default:
-		buf, err = ioutil.ReadAll(this.response.Body)
+	if !this.isExpectedStatus(this.response.StatusCode) {
+		// 错误状态码的body通常包含服务端的错误说明,一并返回
+		buf, _ := this.readBody()
+		this.logResponse(buf)
+		return this.response, buf, newStatusError(this.response, buf)
 	}
+	buf, err := this.readBody()
+	this.logResponse(buf)
 	if err != nil {
 		return this.response, nil, err
 	}
 	return this.response, buf, nil
 }
 
-func (this *Request) EndFile(savePath, saveFileName string) (*http.Response, error) {
+// EndHeaders关闭body并只返回响应,适用于HEAD、OPTIONS等只关心状态码和响应头的请求
+func (this *Request) EndHeaders() (*http.Response, error) {
 	if this.err != nil {
-		return nil, errors.New(this.err.Error())
+		return nil, this.err
+	}
+	_ = this.response.Body.Close()
+	if !this.isExpectedStatus(this.response.StatusCode) {
+		return this.response, newStatusError(this.response, nil)
 	}
+	return this.response, nil
+}
 
-	if this.response.StatusCode != http.StatusOK {
-		return nil, errors.New("Not written")
+// readBody读取解压后的body,无论成功与否body都会被关闭
+func (this *Request) readBody() ([]byte, error) {
+	r, err := this.bodyReader()
+	if err != nil {
+		return nil, err
 	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
 
-	if saveFileName == "" {
-		path := strings.Split(this.request.URL.String(), "/")
-		if len(path) > 1 {
-			saveFileName = path[len(path)-1]
-		}
+// EndStream返回解压后的body流,不做缓冲,调用方负责读取并关闭
+func (this *Request) EndStream() (*http.Response, io.ReadCloser, error) {
+	if this.err != nil {
+		return nil, nil, this.err
 	}
 
-	bodyByte, _ := ioutil.ReadAll(this.response.Body)
-	_ = this.response.Body.Close()
-	err := ioutil.WriteFile(savePath+saveFileName, bodyByte, 0777)
+	if !this.isExpectedStatus(this.response.StatusCode) {
+		_ = this.response.Body.Close()
+		return this.response, nil, newStatusError(this.response, nil)
+	}
+	r, err := this.bodyReader()
 	if err != nil {
-		return nil, errors.New(err.Error())
+		return this.response, nil, err
 	}
+	return this.response, r, nil
+}
+
+// EndReader与EndStream相同,返回解压后不做缓冲的body,调用方负责读取并关闭。
+// 需要未解压的原始body时使用EndRaw
+func (this *Request) EndReader() (*http.Response, io.ReadCloser, error) {
+	return this.EndStream()
+}
 
+// EndRaw返回Send得到的原始响应,不检查状态码也不解压body,调用方负责读取并关闭rsp.Body
+func (this *Request) EndRaw() (*http.Response, error) {
+	if this.err != nil {
+		return nil, this.err
+	}
 	return this.response, nil
 }
+
+// ErrResponseTooLarge表示响应body超过了SetMaxResponseBytes设置的长度
+var ErrResponseTooLarge = errors.New("response body too large")
+
+// SetMaxResponseBytes限制读取的响应body长度(解压后),超过时返回ErrResponseTooLarge,n<=0表示不限制。
+// 对所有End方法和EndStream返回的body都有效,HttpClient.EnableCache和SetDumpWire也最多读取该长度,
+// 用于防止不可信的服务端返回超大body耗尽内存
+func (this *Request) SetMaxResponseBytes(n int64) *Request {
+	this.maxResponse = n
+	return this
+}
+
+func (this *Request) bodyReader() (io.ReadCloser, error) {
+	r, err := this.decodeBody()
+	if err != nil || this.maxResponse <= 0 {
+		return r, err
+	}
+	return &limitedBody{ReadCloser: r, remaining: this.maxResponse}, nil
+}
+
+type maxResponseKey struct{}
+
+// readBodyUpTo读取最多n字节的body(n<=0表示不限制),超过n时complete为false。
+// 读取后rsp.Body被替换为已读部分与剩余部分的拼接,仍然可以从头完整读取
+func readBodyUpTo(rsp *http.Response, n int64) (body []byte, complete bool, err error) {
+	r := io.Reader(rsp.Body)
+	if n > 0 {
+		r = io.LimitReader(rsp.Body, n+1)
+	}
+	body, err = ioutil.ReadAll(r)
+	rsp.Body = &prefixBody{Reader: io.MultiReader(bytes.NewReader(body), rsp.Body), Closer: rsp.Body}
+	return body, err == nil && (n <= 0 || int64(len(body)) <= n), err
+}
+
+type prefixBody struct {
+	io.Reader
+	io.Closer
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+// 多读一个字节来判断是否超过限制
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, ErrResponseTooLarge
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) <= b.remaining {
+		b.remaining -= int64(n)
+		return n, err
+	}
+	n = int(b.remaining)
+	b.remaining = -1
+	return n, ErrResponseTooLarge
+}
+
+// 根据Content-Encoding返回解压后的body,关闭时同时关闭原始body,出错时原始body已被关闭
+func (this *Request) decodeBody() (io.ReadCloser, error) {
+	body := this.response.Body
+	if this.rawBody || this.request.Method == http.MethodHead {
+		// HEAD响应虽然可能带有Content-Encoding,但没有body
+		return body, nil
+	}
+	var r io.ReadCloser
+	var err error
+	switch this.response.Header.Get("Content-Encoding") {
+	case "gzip":
+		r, err = gzip.NewReader(body)
+	case "br":
+		r = cbrotli.NewReader(body)
+	case "deflate":
+		r, err = newDeflateReader(body)
+	case "zstd":
+		var d *zstd.Decoder
+		// 单个body顺序解压,不需要额外的解压goroutine
+		if d, err = zstd.NewReader(body, zstd.WithDecoderConcurrency(1)); err == nil {
+			r = d.IOReadCloser()
+		}
+	default:
+		return body, nil
+	}
+	if err == io.EOF {
+		// 空body(如204)没有压缩头
+		return body, nil
+	}
+	if err != nil {
+		_ = body.Close()
+		return nil, fmt.Errorf("decode %s body: %w", this.response.Header.Get("Content-Encoding"), err)
+	}
+	return &decodedBody{ReadCloser: r, body: body}, nil
+}
+
+type decodedBody struct {
+	io.ReadCloser
+	body io.Closer
+}
+
+func (d *decodedBody) Close() error {
+	err := d.ReadCloser.Close()
+	if e := d.body.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+type progressReader struct {
+	io.ReadCloser
+	read       int64
+	total      int64
+	onProgress func(written, total int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.read += int64(n)
+		r.onProgress(r.read, r.total)
+	}
+	return n, err
+}
+
+// countingBody统计读取的字节数,请求体可能在传输层的goroutine中读取,因此使用原子操作
+type countingBody struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// 按规范deflate应为zlib格式,但不少服务端直接返回裸deflate数据,这里根据头部判断
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	h, err := br.Peek(2)
+	if err != nil && len(h) == 0 {
+		return nil, err
+	}
+	if len(h) == 2 && h[0]&0x0f == 8 && (uint16(h[0])<<8|uint16(h[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
@@ -0,0 +1,83 @@
+package httpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEndBytesTruncatedGzip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(bytes.Repeat([]byte("hello gzip "), 100))
+	zw.Close()
+	truncated := buf.Bytes()[:buf.Len()/2]
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(truncated)
+	}))
+	defer s.Close()
+
+	_, body, err := NewRequest(NewHttpClient()).SetUrl(s.URL).SetAcceptEncoding("gzip").Send().EndBytes()
+	if err == nil {
+		t.Fatalf("expected error for truncated gzip body, got %d bytes", len(body))
+	}
+}
+
+func TestRequestReuseAcrossURLs(t *testing.T) {
+	a := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("a:" + r.Header.Get("X-Token")))
+	}))
+	defer a.Close()
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("b:" + r.Header.Get("X-Token")))
+	}))
+	defer b.Close()
+
+	req := NewRequest(NewHttpClient()).SetHeader("X-Token", "t")
+	_, body, err := req.SetUrl(b.URL).Send().End()
+	if err == nil || body != "b:t" {
+		t.Fatalf("first send: body %q, err %v", body, err)
+	}
+	rsp, body, err := req.SetUrl(a.URL).Send().End()
+	if err != nil || body != "a:t" || rsp.StatusCode != http.StatusOK {
+		t.Fatalf("second send: body %q, err %v", body, err)
+	}
+}
+
+func TestMultipartFileAndFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "avatar.png")
+	if err := os.WriteFile(path, []byte("PNGDATA"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f, h, err := r.FormFile("avatar")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(f)
+		w.Write([]byte(r.FormValue("description") + "|" + r.FormValue("user") + "|" + h.Filename + "|" + string(data)))
+	}))
+	defer s.Close()
+
+	_, body, err := NewRequest(NewHttpClient()).SetMethod("POST").SetUrl(s.URL).AsMultipart().
+		SetData("description", "me").
+		SetFileData("user", "bob", false).
+		SetFileData("avatar", path, true).
+		Send().End()
+	if err != nil || body != "me|bob|avatar.png|PNGDATA" {
+		t.Fatalf("body %q, err %v", body, err)
+	}
+}
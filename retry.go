@@ -0,0 +1,133 @@
+package httpc
+
+import (
+	"context"
+	crand "crypto/rand"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy在每次请求结束后被调用,attempt为已经发送的次数(从1开始),
+// resp和err为本次请求的结果,返回是否重试以及重试前的等待时间
+type RetryPolicy func(attempt int, resp *http.Response, err error) (retry bool, wait time.Duration)
+
+// SetRetry在连接错误或429/502/503/504时最多重试count次,每次间隔backoff(Retry-After优先)。
+// multipart请求体会先读入内存以便重试,SetBodyReader的流式请求体不会重试
+func (this *Request) SetRetry(count int, backoff time.Duration) *Request {
+	this.retry = func(attempt int, resp *http.Response, err error) (bool, time.Duration) {
+		return attempt <= count && isTransientFailure(resp, err), backoff
+	}
+	return this
+}
+
+func (this *Request) SetRetryPolicy(policy RetryPolicy) *Request {
+	this.retry = policy
+	return this
+}
+
+// ExponentialBackoff返回指数退避的重试策略,等待时间为[0, base*2^(attempt-1)]内的随机值,不超过max
+func ExponentialBackoff(count int, base, max time.Duration) RetryPolicy {
+	return func(attempt int, resp *http.Response, err error) (bool, time.Duration) {
+		if attempt > count || !isTransientFailure(resp, err) {
+			return false, 0
+		}
+		wait := max
+		if attempt-1 < 32 {
+			if d := base << uint(attempt-1); d > 0 && d < max {
+				wait = d
+			}
+		}
+		return true, time.Duration(rand.Int63n(int64(wait) + 1))
+	}
+}
+
+// RetryUnsafeMethods允许重试POST、PATCH等非幂等请求。默认只重试GET、HEAD、PUT、DELETE、OPTIONS,
+// 因为服务端可能已经处理了请求只是响应丢失,重试非幂等请求会导致重复下单、重复创建等副作用,
+// 只有确认接口幂等(如带有Idempotency-Key)时才应开启
+func (this *Request) RetryUnsafeMethods(allow bool) *Request {
+	this.retryUnsafe = allow
+	return this
+}
+
+// SetIdempotencyKey设置Idempotency-Key请求头,key为空时生成随机的UUID。
+// 同一个key在重试时保持不变,服务端据此去重,因此设置后POST、PATCH等请求也会重试
+func (this *Request) SetIdempotencyKey(key string) *Request {
+	if key == "" {
+		key = newIdempotencyKey()
+	}
+	return this.SetHeader("Idempotency-Key", key)
+}
+
+func newIdempotencyKey() string {
+	var b [16]byte
+	_, _ = crand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func (this *Request) canRetryMethod() bool {
+	if this.retryUnsafe || this.header.Get("Idempotency-Key") != "" {
+		return true
+	}
+	switch this.method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+func isTransientFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, ErrCircuitOpen)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// 429和503响应的Retry-After可以是秒数或HTTP时间,有效时代替重试策略计算的等待时间
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+	v := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,97 @@
+package httpc
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// TraceInfo是SetTrace(true)时记录的连接各阶段耗时,复用连接时DNS、连接和TLS耗时为0
+type TraceInfo struct {
+	DNSLookup        time.Duration
+	TCPConnect       time.Duration
+	TLSHandshake     time.Duration
+	ServerProcessing time.Duration
+	TimeToFirstByte  time.Duration
+	ConnReused       bool
+	RemoteAddr       string
+}
+
+type tracer struct {
+	mu           sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connStart    time.Time
+	connDone     time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	reused       bool
+	remoteAddr   string
+}
+
+func (t *tracer) set(p *time.Time) {
+	t.mu.Lock()
+	*p = time.Now()
+	t.mu.Unlock()
+}
+
+func (t *tracer) clientTrace() *httptrace.ClientTrace {
+	t.start = time.Now()
+	return &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { t.set(&t.dnsStart) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { t.set(&t.dnsDone) },
+		ConnectStart:      func(string, string) { t.set(&t.connStart) },
+		ConnectDone:       func(string, string, error) { t.set(&t.connDone) },
+		TLSHandshakeStart: func() { t.set(&t.tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { t.set(&t.tlsDone) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.reused = info.Reused
+			if info.Conn != nil {
+				t.remoteAddr = info.Conn.RemoteAddr().String()
+			}
+			t.mu.Unlock()
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.set(&t.wroteRequest) },
+		GotFirstResponseByte: func() { t.set(&t.firstByte) },
+	}
+}
+
+func (t *tracer) info() TraceInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return TraceInfo{
+		DNSLookup:        since(t.dnsStart, t.dnsDone),
+		TCPConnect:       since(t.connStart, t.connDone),
+		TLSHandshake:     since(t.tlsStart, t.tlsDone),
+		ServerProcessing: since(t.wroteRequest, t.firstByte),
+		TimeToFirstByte:  since(t.start, t.firstByte),
+		ConnReused:       t.reused,
+		RemoteAddr:       t.remoteAddr,
+	}
+}
+
+func since(start, end time.Time) time.Duration {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// SetTrace开启后记录DNS解析、建立连接、TLS握手和首字节耗时,通过TraceInfo获取。
+// 重试时只保留最后一次请求的记录
+func (this *Request) SetTrace(enable bool) *Request {
+	this.traceOn = enable
+	return this
+}
+
+func (this *Request) TraceInfo() TraceInfo {
+	if this.trace == nil {
+		return TraceInfo{}
+	}
+	return this.trace.info()
+}
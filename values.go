@@ -0,0 +1,156 @@
+package httpc
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// encodeValues按url标签把结构体编码为url.Values,标签格式与encoding/json相同,如`url:"name,omitempty"`。
+// 没有标签时使用字段名,"-"表示忽略;切片和数组编码为重复的参数;匿名嵌入的结构体字段展开到同一层
+func encodeValues(v interface{}) (url.Values, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return url.Values{}, nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("expected struct, got %s", rv.Kind())
+	}
+	values := url.Values{}
+	if err := encodeStruct(values, rv); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func encodeStruct(values url.Values, rv reflect.Value) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		fv := rv.Field(i)
+		tag := f.Tag.Get("url")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			for fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					break
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				if err := encodeStruct(values, fv); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if opts == "omitempty" && fv.IsZero() {
+			continue
+		}
+		for fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				break
+			}
+			fv = fv.Elem()
+		}
+		if fv.Kind() == reflect.Ptr {
+			// nil指针没有值可以编码
+			continue
+		}
+		if (fv.Kind() == reflect.Slice || fv.Kind() == reflect.Array) && fv.Type().Elem().Kind() != reflect.Uint8 {
+			for j := 0; j < fv.Len(); j++ {
+				s, err := formatValue(fv.Index(j))
+				if err != nil {
+					return fmt.Errorf("field %s: %w", f.Name, err)
+				}
+				values.Add(name, s)
+			}
+			continue
+		}
+		s, err := formatValue(fv)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", f.Name, err)
+		}
+		values.Add(name, s)
+	}
+	return nil
+}
+
+func formatValue(v reflect.Value) (string, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+	if v.Type() == timeType {
+		return v.Interface().(time.Time).Format(time.RFC3339), nil
+	}
+	if s, ok := v.Interface().(fmt.Stringer); ok {
+		return s.String(), nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes()), nil
+		}
+	}
+	return "", fmt.Errorf("unsupported type %s", v.Type())
+}
+
+// SetQueryStruct把结构体的字段按url标签设置为查询参数,如`url:"page,omitempty"`,
+// 切片编码为重复的参数,同名参数以结构体中的值为准。编码错误在End时返回
+func (this *Request) SetQueryStruct(v interface{}) *Request {
+	values, err := encodeValues(v)
+	if err != nil {
+		this.buildErr = fmt.Errorf("encode query: %w", err)
+		return this
+	}
+	for k, vs := range values {
+		this.query[k] = vs
+	}
+	return this
+}
+
+// SetFormStruct把结构体的字段按url标签设置为表单字段,规则与SetQueryStruct相同,相当于对每个字段调用SetData。
+// 编码错误在End时返回
+func (this *Request) SetFormStruct(v interface{}) *Request {
+	values, err := encodeValues(v)
+	if err != nil {
+		this.buildErr = fmt.Errorf("encode form: %w", err)
+		return this
+	}
+	for k, vs := range values {
+		this.data[k] = vs
+	}
+	return this
+}